@@ -0,0 +1,68 @@
+// Command packgen proposes metric packs for the types registered in the
+// receiver scheme. It writes one <kind>.yaml per kind of the group
+// version, to be reviewed before it is added to the built-in packs.
+//
+//	go run ./cmd/packgen -gv quark.netapp.io/v1alpha1 -prefix ntap_ -out /tmp/packs
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	kresmetrics "quark.netapp.io/otel-controller/internal/k8sresourcemetrics"
+)
+
+func main() {
+	gvFlag := flag.String("gv", "quark.netapp.io/v1alpha1", "group/version of the types to generate packs for")
+	kinds := flag.String("kinds", "", "comma separated kinds to generate, defaults to every kind of the group version")
+	prefix := flag.String("prefix", "ntap_", "prefix of the generated metric names")
+	out := flag.String("out", ".", "directory the packs are written to")
+	flag.Parse()
+
+	if err := run(*gvFlag, *kinds, *prefix, *out); err != nil {
+		fmt.Fprintln(os.Stderr, "packgen:", err)
+		os.Exit(1)
+	}
+}
+
+func run(gvFlag string, kinds string, prefix string, out string) error {
+	gv, err := schema.ParseGroupVersion(gvFlag)
+	if err != nil {
+		return err
+	}
+
+	list := kresmetrics.KnownKinds(gv)
+	if kinds != "" {
+		list = strings.Split(kinds, ",")
+	}
+	if len(list) == 0 {
+		return fmt.Errorf("no kinds registered for %s", gv)
+	}
+
+	if err := os.MkdirAll(out, 0o755); err != nil {
+		return err
+	}
+	for _, kind := range list {
+		p, err := kresmetrics.GeneratePack(gv.WithKind(strings.TrimSpace(kind)), prefix)
+		if err != nil {
+			return err
+		}
+		f, err := os.Create(filepath.Join(out, p.Name+".yaml"))
+		if err != nil {
+			return err
+		}
+		err = kresmetrics.WritePack(f, p)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%s: %d metrics\n", p.Name, len(p.Metrics))
+	}
+	return nil
+}
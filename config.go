@@ -2,9 +2,19 @@ package k8sresmetricsreciever
 
 import (
 	"go.opentelemetry.io/collector/receiver/scraperhelper"
+	kresmetrics "quark.netapp.io/otel-controller/internal/k8sresourcemetrics"
 )
 
 type K8sResMetricsConfig struct {
 	scraperhelper.ScraperControllerSettings `mapstructure:",squash"`
-	ResRef                                  string `mapstructure:"resRef"`
+	// ResRef is the path of the YAML file holding the metric definitions.
+	ResRef string `mapstructure:"resRef"`
+	// Packs lists the built-in metric packs to enable, optionally
+	// pinned to a version as name@version.
+	Packs []string `mapstructure:"packs"`
+	// PackSettings switches packs on or off and overrides their
+	// metrics, keyed by pack name.
+	PackSettings map[string]kresmetrics.PackSettings `mapstructure:"packSettings"`
+	// List controls how objects are listed from the API server.
+	List kresmetrics.ListSettings `mapstructure:"list"`
 }
@@ -3,10 +3,12 @@ package k8sresmetricsreciever
 import (
 	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"go.opentelemetry.io/collector/component"
 	"go.opentelemetry.io/collector/confmap/confmaptest"
+	kresmetrics "quark.netapp.io/otel-controller/internal/k8sresourcemetrics"
 	"quark.netapp.io/otel-controller/internal/metadata"
 )
 
@@ -30,4 +32,19 @@ func TestConfig(t *testing.T) {
 	res, ok := cc.(*K8sResMetricsConfig)
 	assert.True(t, ok)
 	assert.Equal(t, "testLoc", res.ResRef)
+	assert.Equal(t, []string{"netappvolume"}, res.Packs)
+	assert.True(t, *res.PackSettings["nodevolset"].Enabled)
+	assert.False(t, *res.PackSettings["netappvolume"].Metrics["ntap_volume_info"].Enabled)
+	assert.Equal(t, "zero", res.PackSettings["netappvolume"].Metrics["ntap_volume_restore_percent"].OnMissing)
+	// Settings left out keep their default.
+	assert.Equal(t, kresmetrics.ListSettings{PageSize: kresmetrics.DefaultPageSize, FromCache: true}, res.List)
+}
+
+func TestConfigValidate(t *testing.T) {
+	cfg := NewFactory().CreateDefaultConfig().(*K8sResMetricsConfig)
+	cfg.CollectionInterval = time.Minute
+	assert.Nil(t, component.ValidateConfig(cfg))
+
+	cfg.List.PageSize = -1
+	assert.ErrorContains(t, component.ValidateConfig(cfg), "list page size -1 is negative")
 }
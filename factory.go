@@ -6,6 +6,7 @@ import (
 	"go.opentelemetry.io/collector/component"
 	"go.opentelemetry.io/collector/consumer"
 	"go.opentelemetry.io/collector/receiver"
+	kresmetrics "quark.netapp.io/otel-controller/internal/k8sresourcemetrics"
 	"quark.netapp.io/otel-controller/internal/metadata"
 )
 
@@ -18,7 +19,9 @@ func NewFactory() receiver.Factory {
 }
 
 func createDefaultConfig() component.Config {
-	return &K8sResMetricsConfig{}
+	return &K8sResMetricsConfig{
+		List: kresmetrics.ListSettings{PageSize: kresmetrics.DefaultPageSize},
+	}
 }
 
 func createMetricsReceiver(
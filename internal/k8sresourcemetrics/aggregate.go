@@ -0,0 +1,138 @@
+package k8sresmetric
+
+import (
+	"fmt"
+	"math"
+)
+
+// Operations of an aggregation.
+const (
+	AggregateSum   = "sum"
+	AggregateCount = "count"
+	AggregateMin   = "min"
+	AggregateMax   = "max"
+	AggregateAvg   = "avg"
+)
+
+// Aggregation combines the data points of a metric sharing the values of
+// the By labels into one data point per group, in place of one per
+// object. The other labels are dropped.
+type Aggregation struct {
+	// Op is sum, count, min, max or avg. Count counts the data points
+	// of the group, including those without a value; the others only
+	// combine the data points with a value.
+	Op string   `yaml:"op"`
+	By []string `yaml:"by,omitempty"`
+}
+
+// validate checks the aggregation of a metric labelled labelNames.
+func (a *Aggregation) validate(labelNames []string) error {
+	switch a.Op {
+	case AggregateSum, AggregateCount, AggregateMin, AggregateMax, AggregateAvg:
+	default:
+		return fmt.Errorf("invalid aggregate op %q, want sum, count, min, max or avg", a.Op)
+	}
+	for _, by := range a.By {
+		if labelIndex(labelNames, by) < 0 {
+			return fmt.Errorf("aggregate by unknown label %s", by)
+		}
+	}
+	return nil
+}
+
+// dataPoint is a value of a metric with its label values.
+type dataPoint struct {
+	value float64
+	// noValue is set when the value is missing and skipped.
+	noValue     bool
+	labelValues []string
+}
+
+// apply groups points by the values of the By labels of labelNames and
+// returns the By labels and the data point of every group, in the order
+// the groups are first seen. Groups without a value to combine yield a
+// data point without value. Counting without By always yields a data
+// point, which is 0 without points.
+func (a *Aggregation) apply(labelNames []string, points []dataPoint) ([]string, []dataPoint) {
+	groups := groupPoints(labelNames, a.By, points)
+	if a.Op == AggregateCount && len(a.By) == 0 && len(groups) == 0 {
+		groups = []*pointGroup{{labelValues: []string{}}}
+	}
+	res := make([]dataPoint, 0, len(groups))
+	for _, g := range groups {
+		agg := dataPoint{noValue: true, labelValues: g.labelValues}
+		if a.Op == AggregateCount {
+			agg.value, agg.noValue = float64(len(g.points)), false
+			res = append(res, agg)
+			continue
+		}
+		n := 0
+		for _, p := range g.points {
+			if p.noValue {
+				continue
+			}
+			switch {
+			case n == 0:
+				agg.value = p.value
+			case a.Op == AggregateMin:
+				agg.value = math.Min(agg.value, p.value)
+			case a.Op == AggregateMax:
+				agg.value = math.Max(agg.value, p.value)
+			default:
+				agg.value += p.value
+			}
+			n++
+		}
+		if n > 0 {
+			agg.noValue = false
+			if a.Op == AggregateAvg {
+				agg.value /= float64(n)
+			}
+		}
+		res = append(res, agg)
+	}
+	return a.By, res
+}
+
+// pointGroup is a group of data points sharing some label values.
+type pointGroup struct {
+	labelValues []string
+	points      []dataPoint
+}
+
+// groupPoints groups points by the values of the by labels of
+// labelNames, in the order the groups are first seen.
+func groupPoints(labelNames []string, by []string, points []dataPoint) []*pointGroup {
+	idx := make([]int, len(by))
+	for i, l := range by {
+		idx[i] = labelIndex(labelNames, l)
+	}
+
+	var groups []*pointGroup
+	byKey := make(map[string]*pointGroup)
+	for _, p := range points {
+		lv := make([]string, len(idx))
+		for i, j := range idx {
+			lv[i] = p.labelValues[j]
+		}
+		key := seriesKey(lv)
+		g, ok := byKey[key]
+		if !ok {
+			g = &pointGroup{labelValues: lv}
+			byKey[key] = g
+			groups = append(groups, g)
+		}
+		g.points = append(g.points, p)
+	}
+	return groups
+}
+
+// labelIndex returns the index of name in labelNames, or -1.
+func labelIndex(labelNames []string, name string) int {
+	for i, l := range labelNames {
+		if l == name {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,96 @@
+package k8sresmetric
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	quarkv1alpha1 "quark.netapp.io/otel-controller/internal/quark/v1alpha1"
+)
+
+func TestCountMetric(t *testing.T) {
+	vol := func(name, level string, online corev1.ConditionStatus) *quarkv1alpha1.NetAppVolume {
+		v := &quarkv1alpha1.NetAppVolume{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "ns"}}
+		v.Status.ServiceLevel = level
+		v.Status.Conditions = []quarkv1alpha1.NetAppVolumeCondition{
+			{Type: quarkv1alpha1.NetAppVolumeResized, Status: corev1.ConditionTrue},
+			{Type: quarkv1alpha1.NetAppVolumeOnline, Status: online},
+		}
+		return v
+	}
+	defer setTestClient(t,
+		vol("a", "premium", corev1.ConditionTrue),
+		vol("b", "premium", corev1.ConditionFalse),
+		vol("c", "premium", corev1.ConditionTrue),
+		vol("d", "standard", corev1.ConditionTrue),
+	)()
+
+	config := `
+metrics:
+  - name: ntap_volumes
+    type: count
+    properties:
+      type: kubernetes
+      object: NetAppVolume
+  - name: ntap_volumes_by_level
+    type: count
+    properties:
+      type: kubernetes
+      object: NetAppVolume
+      groupBy:
+        level: $.status.serviceLevel
+        online: $.status.conditions[?(@.type == 'Online')].status
+      labels:
+        cluster: east
+  - name: ntap_snapshots
+    type: count
+    properties:
+      type: kubernetes
+      object: NetAppVolumeSnapshot
+`
+	tel, _ := newTestTelemetry(t)
+	c, err := SetCollectors(config, nil, ListSettings{}, zap.NewNop(), tel)
+	assert.Nil(t, err)
+	// The volumes are only listed for their metadata when not grouped
+	// by other fields.
+	assert.True(t, c[0].ResourceCollector.(*kMetrics).metricsMap["ntap_volumes"].metadataOnly)
+	assert.False(t, c[0].ResourceCollector.(*kMetrics).metricsMap["ntap_volumes_by_level"].metadataOnly)
+
+	ms := c[0].Collect(context.Background()).ResourceMetrics().At(0).ScopeMetrics().At(0).Metrics()
+	got := make(map[string][]map[string]interface{})
+	for i := 0; i < ms.Len(); i++ {
+		dps := ms.At(i).Gauge().DataPoints()
+		for j := 0; j < dps.Len(); j++ {
+			dp := dps.At(j).Attributes().AsRaw()
+			dp["value"] = dps.At(j).DoubleValue()
+			got[ms.At(i).Name()] = append(got[ms.At(i).Name()], dp)
+		}
+	}
+	assert.Equal(t, []map[string]interface{}{{"value": 4.0}}, got["ntap_volumes"])
+	assert.ElementsMatch(t, []map[string]interface{}{
+		{"value": 2.0, "level": "premium", "online": "True", "cluster": "east"},
+		{"value": 1.0, "level": "premium", "online": "False", "cluster": "east"},
+		{"value": 1.0, "level": "standard", "online": "True", "cluster": "east"},
+	}, got["ntap_volumes_by_level"])
+	// Metrics without objects count 0.
+	assert.Equal(t, []map[string]interface{}{{"value": 0.0}}, got["ntap_snapshots"])
+}
+
+func TestCountMetricInvalid(t *testing.T) {
+	km := &kMetrics{metricsMap: make(map[string]*MetricsInfo)}
+	m := MetricsConfig{Name: "m", MetricType: MetricTypeCount}
+	m.Properties.Value = "$.status.size"
+	assert.EqualError(t, km.RegisterMetric(m), "metric m: count metrics take no value, expr or states")
+
+	m.Properties.Value = ""
+	m.Properties.Labels = map[string]string{"level": "premium"}
+	m.Properties.GroupBy = map[string]string{"level": "$.status.serviceLevel"}
+	assert.EqualError(t, km.RegisterMetric(m), "metric m: label level is both in labels and groupBy")
+
+	m.MetricType = "gauge"
+	m.Properties.Value = "$.status.size"
+	assert.EqualError(t, km.RegisterMetric(m), "metric m: groupBy is only for count metrics")
+}
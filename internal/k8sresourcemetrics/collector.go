@@ -1,20 +1,24 @@
 package k8sresmetric
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
 	"sync"
 	"time"
 
-	log "github.com/sirupsen/logrus"
 	"go.opentelemetry.io/collector/consumer"
 	"go.opentelemetry.io/collector/pdata/pcommon"
 	"go.opentelemetry.io/collector/pdata/pmetric"
+	"go.uber.org/zap"
 	"gopkg.in/yaml.v3"
 )
 
 type ResourceCollector interface {
 	RegisterMetric(m MetricsConfig) error
 	LabelNames(metrics string) []string
-	Update() error
+	Update(ctx context.Context) error
 	Values(metric string) (Result, error)
 }
 
@@ -29,8 +33,22 @@ type Result struct {
 type Collector struct {
 	ResourceCollector
 	MetricConfigList []MetricsConfig
-	lastScrapeTime   time.Time
-	nextConsumer     consumer.Metrics
+	// onMissing holds the parsed onMissing policy of every metric.
+	onMissing map[string]MissingPolicy
+	// aggregate holds the aggregation of the aggregated metrics.
+	aggregate map[string]*Aggregation
+	// histograms holds the settings of the histogram metrics.
+	histograms map[string]*HistogramSettings
+	// series holds, per metric, the label values of every series
+	// emitted by the previous scrape keyed by seriesKey.
+	series         map[string]map[string][]string
+	lastScrapeTime time.Time
+	// refreshTime is the time of the last refresh, which histograms
+	// start at.
+	refreshTime  time.Time
+	nextConsumer consumer.Metrics
+	logger       *zap.Logger
+	telemetry    *Telemetry
 	sync.Mutex
 }
 
@@ -39,17 +57,33 @@ var (
 	scrapeInterval float64 = 25
 )
 
-func NewResourceCollector(resType string) ResourceCollector {
+func NewResourceCollector(resType string, logger *zap.Logger, tel *Telemetry) ResourceCollector {
 
 	switch resType {
 	case "kubernetes":
-		return &kMetrics{metricsMap: make(map[string]*MetricsInfo)}
+		return &kMetrics{metricsMap: make(map[string]*MetricsInfo), listSettings: ListSettings{PageSize: DefaultPageSize}, logger: logger, telemetry: tel}
 	}
 
 	return nil
 }
 
-func (c *Collector) Collect() {
+// NewCollector returns a collector emitting the metrics of rc.
+func NewCollector(rc ResourceCollector, logger *zap.Logger, tel *Telemetry) *Collector {
+	return &Collector{
+		ResourceCollector: rc,
+		onMissing:         make(map[string]MissingPolicy),
+		aggregate:         make(map[string]*Aggregation),
+		histograms:        make(map[string]*HistogramSettings),
+		series:            make(map[string]map[string][]string),
+		logger:            logger,
+		telemetry:         tel,
+	}
+}
+
+// Collect resolves every registered metric and returns the data points.
+// Objects are only re-listed once the scrape interval has elapsed; ctx
+// aborts an in-progress refresh.
+func (c *Collector) Collect(ctx context.Context) pmetric.Metrics {
 
 	c.Lock()
 	defer c.Unlock()
@@ -57,9 +91,19 @@ func (c *Collector) Collect() {
 	// Refresh data only after scrape interval.
 	t := time.Now()
 	elapsed := t.Sub(c.lastScrapeTime)
+	refreshed := false
 	if elapsed.Seconds() >= scrapeInterval {
-		c.Update()
-		c.lastScrapeTime = time.Now()
+		if err := c.Update(ctx); err != nil {
+			c.logger.Error("error updating resources", zap.Error(err))
+		} else {
+			c.lastScrapeTime = time.Now()
+			c.refreshTime = t
+			refreshed = true
+		}
+	}
+	start := pcommon.NewTimestampFromTime(t)
+	if !c.refreshTime.IsZero() {
+		start = pcommon.NewTimestampFromTime(c.refreshTime)
 	}
 
 	md := pmetric.NewMetrics()
@@ -72,50 +116,198 @@ func (c *Collector) Collect() {
 		// Get the value and labels associated with the metric.
 		r, err := c.Values(m.Name)
 		if err != nil {
-			log.Errorf("error resolving metric %v", err)
+			c.logger.Error("error resolving metric", zap.String("metric", m.Name), zap.Error(err))
 			continue
 		}
 		metric := ms.AppendEmpty()
 
 		metric.SetName(m.Name)
 		metric.SetDescription(m.Help)
-		metric.SetUnit(m.Properties.Unit)
-		metric.Sum().SetIsMonotonic(true)
+		metric.SetUnit(metricUnit(m.Properties.Unit))
 
-		sum := metric.SetEmptySum()
-		dps := sum.DataPoints()
 		tim := pcommon.NewTimestampFromTime(t)
+		labels := c.LabelNames(m.Name)
+		points := make([]dataPoint, 0, len(r.Vals))
 		// Range over the result.
-		for _, val := range r.Vals {
+		for i, val := range r.Vals {
 			var v float64
+			noValue := false
 			// convert the value based on the unit.
 			v, err = ConvertUnit(m.Properties.Unit, val)
 			if err != nil {
-				v = 0.0
+				// Like the other evaluation errors, unit errors are
+				// recorded once per refresh.
+				if refreshed && !errors.Is(err, ErrMissingValue) {
+					c.telemetry.RecordEvaluationError(ctx, m.Name, "unit")
+				}
+				policy := c.onMissing[m.Name]
+				switch policy.Action {
+				case OnMissingZero:
+					v = 0.0
+				case OnMissingNaN, OnMissingDefault:
+					v = policy.Value
+				default:
+					// Flag the point so that backends show a gap
+					// instead of a false zero.
+					noValue = true
+				}
+			}
+			points = append(points, dataPoint{value: v, noValue: noValue, labelValues: r.LabelValues[i]})
+		}
+		if a, ok := c.aggregate[m.Name]; ok {
+			labels, points = a.apply(labels, points)
+		}
+
+		// series holds the label values of every series emitted, and
+		// appendStale appends a data point flagged as having no value
+		// and returns its attributes.
+		var series [][]string
+		var appendStale func() pcommon.Map
+		switch m.MetricType {
+		case MetricTypeHistogram, MetricTypeExponentialHistogram:
+			h := c.histograms[m.Name]
+			groups := groupPoints(labels, h.By, points)
+			labels = h.By
+			for _, g := range groups {
+				series = append(series, g.labelValues)
+			}
+			// The histograms describe the objects of the last refresh,
+			// so they are cumulative from that refresh on and start
+			// over with the next one.
+			if m.MetricType == MetricTypeHistogram {
+				hist := metric.SetEmptyHistogram()
+				hist.SetAggregationTemporality(pmetric.AggregationTemporalityCumulative)
+				dps := hist.DataPoints()
+				for _, g := range groups {
+					dp := dps.AppendEmpty()
+					dp.SetStartTimestamp(start)
+					dp.SetTimestamp(tim)
+					h.setHistogram(dp, g.points)
+					putLabels(dp.Attributes(), labels, g.labelValues)
+				}
+				appendStale = func() pcommon.Map {
+					dp := dps.AppendEmpty()
+					dp.SetStartTimestamp(start)
+					dp.SetTimestamp(tim)
+					dp.SetFlags(pmetric.DefaultDataPointFlags.WithNoRecordedValue(true))
+					return dp.Attributes()
+				}
+				break
+			}
+			hist := metric.SetEmptyExponentialHistogram()
+			hist.SetAggregationTemporality(pmetric.AggregationTemporalityCumulative)
+			dps := hist.DataPoints()
+			for _, g := range groups {
+				dp := dps.AppendEmpty()
+				dp.SetStartTimestamp(start)
+				dp.SetTimestamp(tim)
+				h.setExponentialHistogram(dp, g.points)
+				putLabels(dp.Attributes(), labels, g.labelValues)
 			}
-			dp := dps.AppendEmpty()
-			dp.SetTimestamp(tim)
-			dp.SetIntValue(int64(v))
+			appendStale = func() pcommon.Map {
+				dp := dps.AppendEmpty()
+				dp.SetStartTimestamp(start)
+				dp.SetTimestamp(tim)
+				dp.SetFlags(pmetric.DefaultDataPointFlags.WithNoRecordedValue(true))
+				return dp.Attributes()
+			}
+		default:
+			var dps pmetric.NumberDataPointSlice
+			if m.MetricType == MetricTypeCounter {
+				sum := metric.SetEmptySum()
+				sum.SetIsMonotonic(true)
+				sum.SetAggregationTemporality(pmetric.AggregationTemporalityCumulative)
+				dps = sum.DataPoints()
+			} else {
+				dps = metric.SetEmptyGauge().DataPoints()
+			}
+			for _, p := range points {
+				series = append(series, p.labelValues)
+				dp := dps.AppendEmpty()
+				dp.SetTimestamp(tim)
+				dp.SetDoubleValue(p.value)
+				if p.noValue {
+					dp.SetFlags(pmetric.DefaultDataPointFlags.WithNoRecordedValue(true))
+				}
+				putLabels(dp.Attributes(), labels, p.labelValues)
+			}
+			appendStale = func() pcommon.Map {
+				dp := dps.AppendEmpty()
+				dp.SetTimestamp(tim)
+				dp.SetFlags(pmetric.DefaultDataPointFlags.WithNoRecordedValue(true))
+				return dp.Attributes()
+			}
+		}
 
+		// Mark the series whose object has gone away as stale, so that
+		// backends stop showing their last value.
+		current := make(map[string][]string, len(series))
+		for _, lv := range series {
+			current[seriesKey(lv)] = lv
 		}
+		n := len(series)
+		for key, lv := range c.series[m.Name] {
+			if _, ok := current[key]; ok {
+				continue
+			}
+			putLabels(appendStale(), labels, lv)
+			n++
+			fields := []zap.Field{zap.String("metric", m.Name)}
+			for j, l := range lv {
+				fields = append(fields, zap.String(labels[j], l))
+			}
+			c.logger.Debug("object_deleted", fields...)
+		}
+		c.series[m.Name] = current
+
+		c.telemetry.RecordSeries(ctx, m.Name, n)
 	}
+
+	return md
 }
 
-func SetCollectors(config string) error {
+// putLabels sets the labels named labelNames to labelValues in attrs.
+func putLabels(attrs pcommon.Map, labelNames []string, labelValues []string) {
+	for j, l := range labelValues {
+		attrs.PutStr(labelNames[j], l)
+	}
+}
+
+// seriesKey identifies a series of a metric by its label values.
+func seriesKey(labelValues []string) string {
+	return strings.Join(labelValues, "\xff")
+}
+
+// SetCollectors parses the metric definitions in config, adds the metrics
+// of the built-in packs and returns one collector per property type,
+// listing objects as set by list.
+func SetCollectors(config string, packMetrics []MetricsConfig, list ListSettings, logger *zap.Logger, tel *Telemetry) ([]*Collector, error) {
 	exp := &ExporterConfig{}
 
 	err := yaml.Unmarshal([]byte(config), exp)
 	if err != nil {
-		return err
+		return nil, err
 	}
+	exp.Metrics = append(packMetrics, exp.Metrics...)
+
+	names := make(map[string]bool)
+	for _, m := range exp.Metrics {
+		if names[m.Name] {
+			return nil, fmt.Errorf("metric %s is defined more than once", m.Name)
+		}
+		names[m.Name] = true
+	}
+
+	var collectors []*Collector
 	resMap := make(map[string]*Collector)
 	// Iterate over
 	for _, propType := range exp.Objects() {
 
 		// Create instance of the collector
-		c := &Collector{ResourceCollector: &kMetrics{metricsMap: make(map[string]*MetricsInfo)}}
+		c := NewCollector(&kMetrics{metricsMap: make(map[string]*MetricsInfo), listSettings: list, logger: logger, telemetry: tel}, logger, tel)
 
 		resMap[propType] = c
+		collectors = append(collectors, c)
 	}
 
 	// Iterate over all the metrics and register those
@@ -126,9 +318,44 @@ func SetCollectors(config string) error {
 			continue
 		}
 
-		c.RegisterMetric(metric)
+		policy, err := ParseMissingPolicy(metric.Properties.OnMissing)
+		if err != nil {
+			return nil, fmt.Errorf("metric %s: %w", metric.Name, err)
+		}
+		if r := metric.Properties.Reduce; r != "" && r != "min" && r != "max" {
+			return nil, fmt.Errorf("metric %s: invalid reduce %q, want min or max", metric.Name, r)
+		}
+		if err := c.RegisterMetric(metric); err != nil {
+			return nil, err
+		}
+		if metric.MetricType == MetricTypeCount {
+			if metric.Properties.Aggregate != nil {
+				return nil, fmt.Errorf("metric %s: count metrics cannot be aggregated", metric.Name)
+			}
+			c.aggregate[metric.Name] = &Aggregation{Op: AggregateCount, By: c.LabelNames(metric.Name)}
+		}
+		if a := metric.Properties.Aggregate; a != nil {
+			if err := a.validate(c.LabelNames(metric.Name)); err != nil {
+				return nil, fmt.Errorf("metric %s: %w", metric.Name, err)
+			}
+			c.aggregate[metric.Name] = a
+		}
+		if metric.MetricType == MetricTypeHistogram || metric.MetricType == MetricTypeExponentialHistogram {
+			h := metric.Properties.Histogram
+			if h == nil {
+				h = &HistogramSettings{}
+			}
+			if metric.Properties.Aggregate != nil {
+				return nil, fmt.Errorf("metric %s: %s metrics cannot be aggregated", metric.Name, metric.MetricType)
+			}
+			if err := h.validate(metric.MetricType, c.LabelNames(metric.Name)); err != nil {
+				return nil, fmt.Errorf("metric %s: %w", metric.Name, err)
+			}
+			c.histograms[metric.Name] = h
+		}
+		c.onMissing[metric.Name] = policy
 		c.MetricConfigList = append(c.MetricConfigList, metric)
 	}
 
-	return nil
+	return collectors, nil
 }
@@ -1,7 +1,236 @@
 package k8sresmetric
 
-import "testing"
+import (
+	"context"
+	"fmt"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/collector/pdata/pmetric"
+	"go.uber.org/zap"
+)
+
+// fakeCollector serves fixed results instead of querying Kubernetes.
+type fakeCollector struct {
+	labels  map[string][]string
+	results map[string]Result
+}
+
+func (f *fakeCollector) RegisterMetric(m MetricsConfig) error { return nil }
+
+func (f *fakeCollector) LabelNames(metric string) []string { return f.labels[metric] }
+
+func (f *fakeCollector) Update(ctx context.Context) error { return nil }
+
+func (f *fakeCollector) Values(metric string) (Result, error) { return f.results[metric], nil }
+
+func newTestCollector(t *testing.T, f *fakeCollector, metrics ...MetricsConfig) *Collector {
+	tel, _ := newTestTelemetry(t)
+	c := &Collector{
+		ResourceCollector: f,
+		MetricConfigList:  metrics,
+		onMissing:         make(map[string]MissingPolicy),
+		aggregate:         make(map[string]*Aggregation),
+		histograms:        make(map[string]*HistogramSettings),
+		series:            make(map[string]map[string][]string),
+		lastScrapeTime:    time.Now(),
+		logger:            zap.NewNop(),
+		telemetry:         tel,
+	}
+	for _, m := range metrics {
+		p, err := ParseMissingPolicy(m.Properties.OnMissing)
+		assert.Nil(t, err)
+		c.onMissing[m.Name] = p
+		if m.Properties.Aggregate != nil {
+			c.aggregate[m.Name] = m.Properties.Aggregate
+		}
+		if m.Properties.Histogram != nil {
+			c.histograms[m.Name] = m.Properties.Histogram
+		}
+	}
+	return c
+}
 
 func TestCollector(t *testing.T) {
+	f := &fakeCollector{
+		labels: map[string][]string{"size": {"name"}},
+		results: map[string]Result{
+			"size": {Vals: []interface{}{float64(2)}, LabelValues: [][]string{{"vol1"}}},
+		},
+	}
+	m := MetricsConfig{Name: "size", MetricType: "gauge"}
+	m.Properties.Unit = "MiB"
+
+	md := newTestCollector(t, f, m).Collect(context.Background())
+	assert.Equal(t, 1, md.MetricCount())
+	metric := md.ResourceMetrics().At(0).ScopeMetrics().At(0).Metrics().At(0)
+	assert.Equal(t, "By", metric.Unit())
+	dp := metric.Gauge().DataPoints().At(0)
+	assert.Equal(t, float64(2*1048576), dp.DoubleValue())
+	name, _ := dp.Attributes().Get("name")
+	assert.Equal(t, "vol1", name.Str())
+}
+
+func TestCollectOnMissing(t *testing.T) {
+	vals := Result{Vals: []interface{}{nil, "garbage", float64(1)}, LabelValues: [][]string{{}, {}, {}}}
+	f := &fakeCollector{
+		results: map[string]Result{"skip": vals, "zero": vals, "nan": vals, "default": vals},
+	}
+	var metrics []MetricsConfig
+	for name, policy := range map[string]string{"skip": "", "zero": "zero", "nan": "nan", "default": "default:-1"} {
+		m := MetricsConfig{Name: name}
+		m.Properties.OnMissing = policy
+		metrics = append(metrics, m)
+	}
+
+	md := newTestCollector(t, f, metrics...).Collect(context.Background())
+	ms := md.ResourceMetrics().At(0).ScopeMetrics().At(0).Metrics()
+	assert.Equal(t, 4, ms.Len())
+	for i := 0; i < ms.Len(); i++ {
+		dps := ms.At(i).Gauge().DataPoints()
+		assert.Equal(t, 3, dps.Len())
+		assert.Equal(t, float64(1), dps.At(2).DoubleValue())
+		assert.False(t, dps.At(2).Flags().NoRecordedValue())
+		for j := 0; j < 2; j++ {
+			dp := dps.At(j)
+			switch ms.At(i).Name() {
+			case "skip":
+				assert.True(t, dp.Flags().NoRecordedValue())
+			case "zero":
+				assert.Equal(t, float64(0), dp.DoubleValue())
+			case "nan":
+				assert.True(t, math.IsNaN(dp.DoubleValue()))
+			case "default":
+				assert.Equal(t, float64(-1), dp.DoubleValue())
+			}
+			if ms.At(i).Name() != "skip" {
+				assert.Equal(t, pmetric.DefaultDataPointFlags, dp.Flags())
+			}
+		}
+	}
+}
+
+func TestCollectStaleSeries(t *testing.T) {
+	f := &fakeCollector{
+		labels: map[string][]string{"size": {"name"}},
+		results: map[string]Result{
+			"size": {Vals: []interface{}{float64(1), float64(2)}, LabelValues: [][]string{{"vol1"}, {"vol2"}}},
+		},
+	}
+	c := newTestCollector(t, f, MetricsConfig{Name: "size"})
+
+	md := c.Collect(context.Background())
+	assert.Equal(t, 2, md.DataPointCount())
+
+	// vol2 is deleted.
+	f.results["size"] = Result{Vals: []interface{}{float64(1)}, LabelValues: [][]string{{"vol1"}}}
+	md = c.Collect(context.Background())
+	dps := md.ResourceMetrics().At(0).ScopeMetrics().At(0).Metrics().At(0).Gauge().DataPoints()
+	assert.Equal(t, 2, dps.Len())
+	assert.False(t, dps.At(0).Flags().NoRecordedValue())
+	assert.True(t, dps.At(1).Flags().NoRecordedValue())
+	name, _ := dps.At(1).Attributes().Get("name")
+	assert.Equal(t, "vol2", name.Str())
+
+	// The stale marker is only sent once.
+	md = c.Collect(context.Background())
+	assert.Equal(t, 1, md.DataPointCount())
+}
+
+func TestCollectAggregate(t *testing.T) {
+	f := &fakeCollector{
+		labels:  map[string][]string{},
+		results: map[string]Result{},
+	}
+	vals := Result{
+		Vals: []interface{}{float64(1), float64(4), nil, float64(2), nil},
+		LabelValues: [][]string{
+			{"vol1", "premium", "a"},
+			{"vol2", "standard", "a"},
+			{"vol3", "premium", "a"},
+			{"vol4", "premium", "b"},
+			{"vol5", "flex", "b"},
+		},
+	}
+	want := map[string][]float64{
+		AggregateSum:   {1, 4, 2, 0},
+		AggregateCount: {2, 1, 1, 1},
+		AggregateMin:   {1, 4, 2, 0},
+		AggregateMax:   {1, 4, 2, 0},
+		AggregateAvg:   {1, 4, 2, 0},
+	}
+	var metrics []MetricsConfig
+	for op := range want {
+		f.labels[op] = []string{"name", "level", "zone"}
+		f.results[op] = vals
+		m := MetricsConfig{Name: op}
+		m.Properties.Aggregate = &Aggregation{Op: op, By: []string{"level", "zone"}}
+		metrics = append(metrics, m)
+	}
+
+	c := newTestCollector(t, f, metrics...)
+	ms := c.Collect(context.Background()).ResourceMetrics().At(0).ScopeMetrics().At(0).Metrics()
+	assert.Equal(t, len(want), ms.Len())
+	for i := 0; i < ms.Len(); i++ {
+		dps := ms.At(i).Gauge().DataPoints()
+		var got []float64
+		var groups []map[string]interface{}
+		for j := 0; j < dps.Len(); j++ {
+			got = append(got, dps.At(j).DoubleValue())
+			groups = append(groups, dps.At(j).Attributes().AsRaw())
+		}
+		assert.Equal(t, want[ms.At(i).Name()], got, ms.At(i).Name())
+		assert.Equal(t, []map[string]interface{}{
+			{"level": "premium", "zone": "a"},
+			{"level": "standard", "zone": "a"},
+			{"level": "premium", "zone": "b"},
+			{"level": "flex", "zone": "b"},
+		}, groups)
+		// Groups without a value are flagged unless counted.
+		assert.Equal(t, ms.At(i).Name() != AggregateCount, dps.At(3).Flags().NoRecordedValue())
+	}
+
+	// The volumes of zone b go away.
+	for op := range want {
+		f.results[op] = Result{Vals: vals.Vals[:3], LabelValues: vals.LabelValues[:3]}
+	}
+	ms = c.Collect(context.Background()).ResourceMetrics().At(0).ScopeMetrics().At(0).Metrics()
+	dps := ms.At(0).Gauge().DataPoints()
+	assert.Equal(t, 4, dps.Len())
+	assert.True(t, dps.At(2).Flags().NoRecordedValue())
+	assert.True(t, dps.At(3).Flags().NoRecordedValue())
+}
+
+func TestSetCollectorsAggregate(t *testing.T) {
+	config := `
+metrics:
+  - name: ntap_volumes
+    type: gauge
+    properties:
+      type: kubernetes
+      object: NetAppVolume
+      value: $.metadata.name
+      labels:
+        level: $.spec.serviceLevel
+      aggregate:
+        op: count
+        by: [%s]
+`
+	tel, _ := newTestTelemetry(t)
+	c, err := SetCollectors(fmt.Sprintf(config, "level"), nil, ListSettings{}, zap.NewNop(), tel)
+	assert.Nil(t, err)
+	assert.Equal(t, &Aggregation{Op: AggregateCount, By: []string{"level"}}, c[0].aggregate["ntap_volumes"])
+
+	_, err = SetCollectors(fmt.Sprintf(config, "zone"), nil, ListSettings{}, zap.NewNop(), tel)
+	assert.EqualError(t, err, "metric ntap_volumes: aggregate by unknown label zone")
+}
 
+func TestAggregationValidate(t *testing.T) {
+	labels := []string{"name", "level"}
+	assert.Nil(t, (&Aggregation{Op: AggregateCount}).validate(labels))
+	assert.Nil(t, (&Aggregation{Op: AggregateSum, By: []string{"level"}}).validate(labels))
+	assert.EqualError(t, (&Aggregation{Op: "median"}).validate(labels), `invalid aggregate op "median", want sum, count, min, max or avg`)
+	assert.EqualError(t, (&Aggregation{Op: AggregateSum, By: []string{"zone"}}).validate(labels), "aggregate by unknown label zone")
 }
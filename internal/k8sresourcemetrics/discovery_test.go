@@ -0,0 +1,139 @@
+package k8sresmetric
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	fakediscovery "k8s.io/client-go/discovery/fake"
+	clienttesting "k8s.io/client-go/testing"
+)
+
+// setTestDiscovery points discovery at a fake serving resources and
+// returns it with a function restoring the previous state.
+func setTestDiscovery(t *testing.T, resources ...*metav1.APIResourceList) (*fakediscovery.FakeDiscovery, func()) {
+	prevClient, prevMapper, prevNames, prevLast := dClient, restMapper, objectNames, lastDiscovery
+
+	fake := &fakediscovery.FakeDiscovery{Fake: &clienttesting.Fake{}}
+	fake.Resources = resources
+	dClient = fake
+	lastDiscovery = time.Now()
+	assert.Nil(t, setGVKMap())
+
+	return fake, func() {
+		dClient, restMapper, objectNames, lastDiscovery = prevClient, prevMapper, prevNames, prevLast
+	}
+}
+
+func TestGetGVKRediscovers(t *testing.T) {
+	fake, restore := setTestDiscovery(t, &metav1.APIResourceList{
+		GroupVersion: "v1",
+		APIResources: []metav1.APIResource{{Name: "pods", Kind: "Pod", ShortNames: []string{"po"}}},
+	})
+	defer restore()
+
+	gvk, err := getGVK("Pod")
+	assert.Nil(t, err)
+	assert.Equal(t, schema.GroupVersionKind{Version: "v1", Kind: "Pod"}, gvk)
+	_, err = getGVK("NetAppVolume")
+	assert.ErrorContains(t, err, "GVK not found for resource NetAppVolume")
+
+	// The CRD is installed after the collector started.
+	fake.Resources = append(fake.Resources, &metav1.APIResourceList{
+		GroupVersion: "quark.netapp.io/v1alpha1",
+		APIResources: []metav1.APIResource{{Name: "netappvolumes", Kind: "NetAppVolume", ShortNames: []string{"nv"}}},
+	})
+	// Misses only trigger a discovery once discoveryMissInterval has
+	// passed since the last one.
+	_, err = getGVK("NetAppVolume")
+	assert.NotNil(t, err)
+
+	lastDiscovery = time.Now().Add(-discoveryMissInterval)
+	gvk, err = getGVK("NetAppVolume")
+	assert.Nil(t, err)
+	assert.Equal(t, schema.GroupVersionKind{Group: "quark.netapp.io", Version: "v1alpha1", Kind: "NetAppVolume"}, gvk)
+	gvk, err = getGVK("nv")
+	assert.Nil(t, err)
+	assert.Equal(t, "NetAppVolume", gvk.Kind)
+
+	// Periodic refreshes pick up removed kinds too.
+	fake.Resources = fake.Resources[1:]
+	assert.Nil(t, refreshGVKMap(discoveryInterval))
+	_, err = resolveObject("Pod")
+	assert.Nil(t, err, "refreshed too early")
+	lastDiscovery = time.Now().Add(-discoveryInterval)
+	assert.Nil(t, refreshGVKMap(discoveryInterval))
+	_, err = resolveObject("Pod")
+	assert.ErrorIs(t, err, errObjectNotFound)
+}
+
+func TestResolveObject(t *testing.T) {
+	_, restore := setTestDiscovery(t,
+		&metav1.APIResourceList{
+			GroupVersion: "v1",
+			APIResources: []metav1.APIResource{
+				{Name: "events", SingularName: "event", Kind: "Event", ShortNames: []string{"ev"}},
+				{Name: "pods", SingularName: "pod", Kind: "Pod", ShortNames: []string{"po"}},
+				{Name: "pods/status", Kind: "Pod"},
+			},
+		},
+		&metav1.APIResourceList{
+			GroupVersion: "events.k8s.io/v1",
+			APIResources: []metav1.APIResource{{Name: "events", SingularName: "event", Kind: "Event", ShortNames: []string{"ev"}}},
+		},
+		// The first version of a group is its preferred version.
+		&metav1.APIResourceList{
+			GroupVersion: "storage.example.com/v1",
+			APIResources: []metav1.APIResource{{Name: "volumes", Kind: "Volume", ShortNames: []string{"vol", "svol"}}},
+		},
+		&metav1.APIResourceList{
+			GroupVersion: "storage.example.com/v1beta1",
+			APIResources: []metav1.APIResource{{Name: "volumes", Kind: "Volume", ShortNames: []string{"vol", "svol"}}},
+		},
+		&metav1.APIResourceList{
+			GroupVersion: "backup.example.com/v1",
+			APIResources: []metav1.APIResource{{Name: "volumes", Kind: "Volume"}},
+		},
+	)
+	defer restore()
+
+	core := schema.GroupVersionKind{Version: "v1", Kind: "Event"}
+	events := schema.GroupVersionKind{Group: "events.k8s.io", Version: "v1", Kind: "Event"}
+	storage := schema.GroupVersionKind{Group: "storage.example.com", Version: "v1", Kind: "Volume"}
+	for ref, want := range map[string]schema.GroupVersionKind{
+		"Event.events.k8s.io":                events,
+		"events.events.k8s.io":               events,
+		"events.k8s.io/v1/Event":             events,
+		"/v1/Event":                          core,
+		"Volume.storage.example.com":         storage,
+		"vol":                                storage,
+		"svol":                               storage,
+		"volume.storage.example.com":         storage,
+		"storage.example.com/v1beta1/Volume": {Group: "storage.example.com", Version: "v1beta1", Kind: "Volume"},
+		"po":                                 {Version: "v1", Kind: "Pod"},
+	} {
+		gvk, err := resolveObject(ref)
+		assert.Nil(t, err, ref)
+		assert.Equal(t, want, gvk, ref)
+	}
+
+	// The core group does not win over other groups serving the name.
+	for _, ref := range []string{"Event", "events", "ev"} {
+		_, err := resolveObject(ref)
+		assert.EqualError(t, err, fmt.Sprintf(`object %q is ambiguous, qualify it with its group: /v1/Event, Event.events.k8s.io`, ref))
+	}
+
+	_, err := resolveObject("Volume")
+	assert.EqualError(t, err, `object "Volume" is ambiguous, qualify it with its group: Volume.backup.example.com, Volume.storage.example.com`)
+	_, err = resolveObject("volumes")
+	assert.ErrorContains(t, err, "is ambiguous")
+	_, err = resolveObject("Snapshot")
+	assert.ErrorIs(t, err, errObjectNotFound)
+	_, err = resolveObject("storage.example.com/v2/Volume")
+	assert.ErrorIs(t, err, errObjectNotFound)
+	_, err = resolveObject("v1/Pod")
+	assert.ErrorContains(t, err, "want group/version/Kind")
+}
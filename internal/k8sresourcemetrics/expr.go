@@ -0,0 +1,531 @@
+package k8sresmetric
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// expression is a parsed expr of a derived metric. Expressions are
+// arithmetic over bindings, values selected by named paths, e.g.
+//
+//	size > 0 ? used / size : null
+//
+// They support numbers, 'strings', true, false and null, the operators
+// + - * / %, comparisons, && || !, the conditional c ? a : b, the null
+// coalescing a ?? b and the functions min, max and abs. Operands of
+// arithmetic and ordering are coerced like values, so quantities such
+// as 10Gi are numbers. == and != only compare numbers as such when both
+// operands are, and other values as strings, so that 'Ready' != 1.
+//
+// A null operand makes arithmetic and ordering null, as does dividing by
+// zero, and a null result is handled by the onMissing policy. null is
+// false in conditions.
+type expression struct {
+	root exprNode
+}
+
+// exprNode is a node of the expression tree. vars returns the value of a
+// binding.
+type exprNode interface {
+	eval(vars func(string) (interface{}, error)) (interface{}, error)
+}
+
+// parseExpression parses expr. Identifiers must be one of names.
+func parseExpression(expr string, names []string) (*expression, error) {
+	p := &exprParser{src: expr, names: names}
+	if err := p.next(); err != nil {
+		return nil, err
+	}
+	root, err := p.parseTernary()
+	if err != nil {
+		return nil, err
+	}
+	if p.tok.kind != tokEOF {
+		return nil, p.errorf("unexpected %q", p.tok.text)
+	}
+	return &expression{root: root}, nil
+}
+
+// Eval evaluates the expression, resolving bindings through vars.
+func (e *expression) Eval(vars func(string) (interface{}, error)) (interface{}, error) {
+	return e.root.eval(vars)
+}
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokNumber
+	tokString
+	tokIdent
+	tokOp
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	pos  int
+}
+
+type exprParser struct {
+	src   string
+	pos   int
+	tok   token
+	names []string
+}
+
+func (p *exprParser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("at %d: %s", p.tok.pos, fmt.Sprintf(format, args...))
+}
+
+// operators lists the operators, longest first.
+var operators = []string{"??", "==", "!=", "<=", ">=", "&&", "||", "+", "-", "*", "/", "%", "<", ">", "!", "?", ":", "(", ")", ","}
+
+// next reads the next token.
+func (p *exprParser) next() error {
+	for p.pos < len(p.src) && unicode.IsSpace(rune(p.src[p.pos])) {
+		p.pos++
+	}
+	start := p.pos
+	if p.pos == len(p.src) {
+		p.tok = token{kind: tokEOF, pos: start}
+		return nil
+	}
+
+	c := p.src[p.pos]
+	switch {
+	case c >= '0' && c <= '9' || c == '.':
+		for p.pos < len(p.src) && (p.src[p.pos] >= '0' && p.src[p.pos] <= '9' || p.src[p.pos] == '.' ||
+			p.src[p.pos] == 'e' || p.src[p.pos] == 'E' ||
+			(p.src[p.pos] == '-' || p.src[p.pos] == '+') && (p.src[p.pos-1] == 'e' || p.src[p.pos-1] == 'E')) {
+			p.pos++
+		}
+		p.tok = token{kind: tokNumber, text: p.src[start:p.pos], pos: start}
+		return nil
+	case c == '\'' || c == '"':
+		end := strings.IndexByte(p.src[p.pos+1:], c)
+		if end < 0 {
+			return fmt.Errorf("at %d: unterminated string", start)
+		}
+		p.pos += end + 2
+		p.tok = token{kind: tokString, text: p.src[start+1 : p.pos-1], pos: start}
+		return nil
+	case c == '_' || unicode.IsLetter(rune(c)):
+		for p.pos < len(p.src) && (p.src[p.pos] == '_' || unicode.IsLetter(rune(p.src[p.pos])) || unicode.IsDigit(rune(p.src[p.pos]))) {
+			p.pos++
+		}
+		p.tok = token{kind: tokIdent, text: p.src[start:p.pos], pos: start}
+		return nil
+	}
+	for _, op := range operators {
+		if strings.HasPrefix(p.src[p.pos:], op) {
+			p.pos += len(op)
+			p.tok = token{kind: tokOp, text: op, pos: start}
+			return nil
+		}
+	}
+	return fmt.Errorf("at %d: unexpected %q", start, c)
+}
+
+// accept consumes the current token when it is the operator op.
+func (p *exprParser) accept(op string) (bool, error) {
+	if p.tok.kind != tokOp || p.tok.text != op {
+		return false, nil
+	}
+	return true, p.next()
+}
+
+func (p *exprParser) expect(op string) error {
+	ok, err := p.accept(op)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return p.errorf("expected %q", op)
+	}
+	return nil
+}
+
+func (p *exprParser) parseTernary() (exprNode, error) {
+	cond, err := p.parseCoalesce()
+	if err != nil {
+		return nil, err
+	}
+	if ok, err := p.accept("?"); err != nil || !ok {
+		return cond, err
+	}
+	then, err := p.parseTernary()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expect(":"); err != nil {
+		return nil, err
+	}
+	els, err := p.parseTernary()
+	if err != nil {
+		return nil, err
+	}
+	return &condNode{cond: cond, then: then, els: els}, nil
+}
+
+// parseBinary parses a left associative chain of the operators ops, with
+// operands parsed by operand.
+func (p *exprParser) parseBinary(operand func() (exprNode, error), ops ...string) (exprNode, error) {
+	left, err := operand()
+	if err != nil {
+		return nil, err
+	}
+	for p.tok.kind == tokOp && containsString(ops, p.tok.text) {
+		op := p.tok.text
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		right, err := operand()
+		if err != nil {
+			return nil, err
+		}
+		left = &binaryNode{op: op, left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *exprParser) parseCoalesce() (exprNode, error) {
+	return p.parseBinary(p.parseOr, "??")
+}
+
+func (p *exprParser) parseOr() (exprNode, error) {
+	return p.parseBinary(p.parseAnd, "||")
+}
+
+func (p *exprParser) parseAnd() (exprNode, error) {
+	return p.parseBinary(p.parseComparison, "&&")
+}
+
+func (p *exprParser) parseComparison() (exprNode, error) {
+	return p.parseBinary(p.parseAdditive, "==", "!=", "<", "<=", ">", ">=")
+}
+
+func (p *exprParser) parseAdditive() (exprNode, error) {
+	return p.parseBinary(p.parseMultiplicative, "+", "-")
+}
+
+func (p *exprParser) parseMultiplicative() (exprNode, error) {
+	return p.parseBinary(p.parseUnary, "*", "/", "%")
+}
+
+func (p *exprParser) parseUnary() (exprNode, error) {
+	if p.tok.kind == tokOp && (p.tok.text == "-" || p.tok.text == "!") {
+		op := p.tok.text
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &unaryNode{op: op, operand: operand}, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *exprParser) parsePrimary() (exprNode, error) {
+	tok := p.tok
+	switch tok.kind {
+	case tokNumber:
+		f, err := strconv.ParseFloat(tok.text, 64)
+		if err != nil {
+			return nil, p.errorf("invalid number %q", tok.text)
+		}
+		return constNode{f}, p.next()
+	case tokString:
+		return constNode{tok.text}, p.next()
+	case tokIdent:
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		switch tok.text {
+		case "null":
+			return constNode{nil}, nil
+		case "true":
+			return constNode{true}, nil
+		case "false":
+			return constNode{false}, nil
+		}
+		if ok, err := p.accept("("); err != nil {
+			return nil, err
+		} else if ok {
+			return p.parseCall(tok)
+		}
+		if !containsString(p.names, tok.text) {
+			return nil, fmt.Errorf("at %d: unknown binding %q", tok.pos, tok.text)
+		}
+		return varNode(tok.text), nil
+	case tokOp:
+		if tok.text == "(" {
+			if err := p.next(); err != nil {
+				return nil, err
+			}
+			n, err := p.parseTernary()
+			if err != nil {
+				return nil, err
+			}
+			return n, p.expect(")")
+		}
+	case tokEOF:
+		return nil, p.errorf("unexpected end of expression")
+	}
+	return nil, p.errorf("unexpected %q", tok.text)
+}
+
+func (p *exprParser) parseCall(name token) (exprNode, error) {
+	if name.text != "min" && name.text != "max" && name.text != "abs" {
+		return nil, fmt.Errorf("at %d: unknown function %q", name.pos, name.text)
+	}
+	call := &callNode{name: name.text}
+	for {
+		arg, err := p.parseTernary()
+		if err != nil {
+			return nil, err
+		}
+		call.args = append(call.args, arg)
+		if ok, err := p.accept(","); err != nil {
+			return nil, err
+		} else if !ok {
+			break
+		}
+	}
+	if err := p.expect(")"); err != nil {
+		return nil, err
+	}
+	if name.text == "abs" && len(call.args) != 1 {
+		return nil, fmt.Errorf("at %d: abs takes one argument", name.pos)
+	}
+	return call, nil
+}
+
+func containsString(list []string, s string) bool {
+	for _, l := range list {
+		if l == s {
+			return true
+		}
+	}
+	return false
+}
+
+type constNode struct {
+	v interface{}
+}
+
+func (n constNode) eval(func(string) (interface{}, error)) (interface{}, error) {
+	return n.v, nil
+}
+
+type varNode string
+
+func (n varNode) eval(vars func(string) (interface{}, error)) (interface{}, error) {
+	return vars(string(n))
+}
+
+type condNode struct {
+	cond, then, els exprNode
+}
+
+func (n *condNode) eval(vars func(string) (interface{}, error)) (interface{}, error) {
+	c, err := n.cond.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+	if truthy(c) {
+		return n.then.eval(vars)
+	}
+	return n.els.eval(vars)
+}
+
+type unaryNode struct {
+	op      string
+	operand exprNode
+}
+
+func (n *unaryNode) eval(vars func(string) (interface{}, error)) (interface{}, error) {
+	v, err := n.operand.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+	if n.op == "!" {
+		return !truthy(v), nil
+	}
+	if v == nil {
+		return nil, nil
+	}
+	f, err := exprNumber(v)
+	if err != nil {
+		return nil, err
+	}
+	return -f, nil
+}
+
+type binaryNode struct {
+	op          string
+	left, right exprNode
+}
+
+func (n *binaryNode) eval(vars func(string) (interface{}, error)) (interface{}, error) {
+	l, err := n.left.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+	// The right operand is only evaluated when needed.
+	switch n.op {
+	case "??":
+		if l != nil {
+			return l, nil
+		}
+		return n.right.eval(vars)
+	case "&&":
+		if !truthy(l) {
+			return false, nil
+		}
+		r, err := n.right.eval(vars)
+		return truthy(r), err
+	case "||":
+		if truthy(l) {
+			return true, nil
+		}
+		r, err := n.right.eval(vars)
+		return truthy(r), err
+	}
+
+	r, err := n.right.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+	switch n.op {
+	case "==":
+		return exprEqual(l, r), nil
+	case "!=":
+		return !exprEqual(l, r), nil
+	}
+
+	if l == nil || r == nil {
+		return nil, nil
+	}
+	lf, err := exprNumber(l)
+	if err != nil {
+		return nil, err
+	}
+	rf, err := exprNumber(r)
+	if err != nil {
+		return nil, err
+	}
+	switch n.op {
+	case "+":
+		return lf + rf, nil
+	case "-":
+		return lf - rf, nil
+	case "*":
+		return lf * rf, nil
+	case "/":
+		if rf == 0 {
+			return nil, nil
+		}
+		return lf / rf, nil
+	case "%":
+		if rf == 0 {
+			return nil, nil
+		}
+		return math.Mod(lf, rf), nil
+	case "<":
+		return lf < rf, nil
+	case "<=":
+		return lf <= rf, nil
+	case ">":
+		return lf > rf, nil
+	case ">=":
+		return lf >= rf, nil
+	}
+	return nil, fmt.Errorf("unknown operator %q", n.op)
+}
+
+type callNode struct {
+	name string
+	args []exprNode
+}
+
+func (n *callNode) eval(vars func(string) (interface{}, error)) (interface{}, error) {
+	var result float64
+	for i, a := range n.args {
+		v, err := a.eval(vars)
+		if err != nil || v == nil {
+			return nil, err
+		}
+		f, err := exprNumber(v)
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case n.name == "abs":
+			return math.Abs(f), nil
+		case i == 0, n.name == "min" && f < result, n.name == "max" && f > result:
+			result = f
+		}
+	}
+	return result, nil
+}
+
+// exprNumber returns v as a number.
+func exprNumber(v interface{}) (float64, error) {
+	f, err := CoerceToFloat64(v)
+	if err != nil {
+		return 0, fmt.Errorf("%v is not a number", v)
+	}
+	return f, nil
+}
+
+// exprEqual compares values as numbers when both are, and as strings
+// otherwise. null only equals null.
+func exprEqual(l, r interface{}) bool {
+	if l == nil || r == nil {
+		return l == nil && r == nil
+	}
+	lf, lOk := exprNumeric(l)
+	rf, rOk := exprNumeric(r)
+	if lOk && rOk {
+		return lf == rf
+	}
+	return fmt.Sprint(l) == fmt.Sprint(r)
+}
+
+// exprNumeric returns v as a number if it is one. Unlike arithmetic
+// operands, strings such as 'Ready' or '10Gi' are not coerced.
+func exprNumeric(v interface{}) (float64, bool) {
+	if _, ok := v.(string); ok {
+		return 0, false
+	}
+	f, err := CoerceToFloat64(v)
+	return f, err == nil
+}
+
+// truthy reports whether v holds in a condition: null is false, and
+// other values are true unless they are false, a zero number or an
+// empty string. Strings are not coerced, so 'False' is true.
+func truthy(v interface{}) bool {
+	switch t := v.(type) {
+	case nil:
+		return false
+	case bool:
+		return t
+	case string:
+		return t != ""
+	}
+	if f, ok := exprNumeric(v); ok {
+		return f != 0
+	}
+	return true
+}
+
+// errMultipleValues is returned for bindings selecting several nodes.
+var errMultipleValues = errors.New("binding selects several values")
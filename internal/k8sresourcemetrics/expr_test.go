@@ -0,0 +1,137 @@
+package k8sresmetric
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	quarkv1alpha1 "quark.netapp.io/otel-controller/internal/quark/v1alpha1"
+)
+
+func TestExpression(t *testing.T) {
+	vars := map[string]interface{}{
+		"used":    "512Mi",
+		"size":    "1Gi",
+		"zero":    0.0,
+		"alive":   3.0,
+		"total":   4.0,
+		"missing": nil,
+		"state":   "Online",
+		"ready":   "True",
+	}
+	names := make([]string, 0, len(vars))
+	for name := range vars {
+		names = append(names, name)
+	}
+	lookup := func(name string) (interface{}, error) {
+		return vars[name], nil
+	}
+
+	for expr, want := range map[string]interface{}{
+		"used / size":                       0.5,
+		"size - used":                       536870912.0,
+		"alive / total":                     0.75,
+		"zero > 0 ? alive / zero : null":    nil,
+		"alive / zero":                      nil,
+		"total % zero":                      nil,
+		"missing + 1":                       nil,
+		"-missing":                          nil,
+		"missing > 1":                       nil,
+		"missing ?? 0":                      0.0,
+		"alive ?? 0":                        3.0,
+		"missing == null":                   true,
+		"alive != null":                     true,
+		"state == 'Online' ? 1 : 0":         1.0,
+		"state != \"Online\"":               false,
+		"ready == 1":                        false,
+		"ready == 'True'":                   true,
+		"ready ? 1 : 2":                     1.0,
+		"state == 'online'":                 false,
+		"missing ? 1 : 2":                   2.0,
+		"!missing && alive > 2":             true,
+		"alive < 2 || total >= 4":           true,
+		"(1 + 2) * 3":                       9.0,
+		"1 + 2 * 3":                         7.0,
+		"10 % 4":                            2.0,
+		"-alive + 2":                        -1.0,
+		"1.5e3":                             1500.0,
+		"min(alive, total, 2)":              2.0,
+		"max(alive, total)":                 4.0,
+		"abs(alive - total)":                1.0,
+		"min(alive, missing)":               nil,
+		"alive > 2 ? total > 3 ? 1 : 2 : 3": 1.0,
+	} {
+		e, err := parseExpression(expr, names)
+		if !assert.Nil(t, err, expr) {
+			continue
+		}
+		v, err := e.Eval(lookup)
+		assert.Nil(t, err, expr)
+		assert.Equal(t, want, v, expr)
+	}
+
+	for _, expr := range []string{
+		"alive +",
+		"unknown * 2",
+		"log(alive)",
+		"abs(alive, total)",
+		"'Online",
+		"alive ? 1",
+		"alive total",
+		"(alive",
+		"alive # 2",
+		"",
+	} {
+		_, err := parseExpression(expr, names)
+		assert.NotNil(t, err, expr)
+	}
+
+	e, err := parseExpression("state * 2", names)
+	assert.Nil(t, err)
+	_, err = e.Eval(lookup)
+	assert.EqualError(t, err, "Online is not a number")
+
+	// Bindings are only resolved when used.
+	e, err = parseExpression("zero > 0 ? alive / zero : total", names)
+	assert.Nil(t, err)
+	_, err = e.Eval(func(name string) (interface{}, error) {
+		if name == "alive" {
+			return nil, errors.New("alive resolved")
+		}
+		return vars[name], nil
+	})
+	assert.Nil(t, err)
+}
+
+func TestDerivedMetric(t *testing.T) {
+	m := MetricsConfig{Name: "ntap_volume_used_ratio", MetricType: "gauge"}
+	m.Properties.PropertyType = "kubernetes"
+	m.Properties.Object = "NetAppVolume"
+	m.Properties.Expr = "size > 0 ? provisioned / size : null"
+	m.Properties.Bindings = map[string]string{"provisioned": "$.status.provisionedSize", "size": "$.status.size"}
+	m.Properties.Labels = map[string]string{"name": "$.metadata.name"}
+
+	vol := func(name string, size, provisioned string) *quarkv1alpha1.NetAppVolume {
+		v := &quarkv1alpha1.NetAppVolume{ObjectMeta: metav1.ObjectMeta{Name: name}}
+		v.Status.Size = resource.MustParse(size)
+		v.Status.ProvisionedSize = resource.MustParse(provisioned)
+		return v
+	}
+	points := evalMetrics(t, []MetricsConfig{m}, vol("a", "100Gi", "25Gi"), vol("b", "0", "1Gi"))
+	assert.Equal(t, []map[string]interface{}{
+		{"value": 0.25, "name": "a"},
+		{"value": ErrMissingValue, "name": "b"},
+	}, points["ntap_volume_used_ratio"])
+
+	km := &kMetrics{metricsMap: make(map[string]*MetricsInfo)}
+	m.Properties.Value = "$.status.size"
+	assert.ErrorContains(t, km.RegisterMetric(m), "value and expr cannot both be set")
+	m.Properties.Value = ""
+	m.Properties.Expr = "used / size"
+	assert.ErrorContains(t, km.RegisterMetric(m), `invalid expr "used / size": at 0: unknown binding "used"`)
+	m.Properties.Expr = "size"
+	m.Properties.Bindings = map[string]string{"size": "$.status.conditions[*].status"}
+	assert.Nil(t, km.RegisterMetric(m))
+}
@@ -0,0 +1,191 @@
+package k8sresmetric
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+
+	"go.opentelemetry.io/collector/pdata/pmetric"
+)
+
+// Bounds of the scale of an exponential histogram.
+const (
+	minExponentialScale = -10
+	maxExponentialScale = 20
+	// maxExponentialBuckets bounds the positive and the negative
+	// buckets of an exponential histogram, as in the OpenTelemetry SDK.
+	maxExponentialBuckets = 160
+)
+
+// HistogramSettings buckets the values of the objects of a histogram or
+// exponential_histogram metric. Every scrape emits one histogram per
+// group of objects sharing the values of the By labels, holding the
+// values of that scrape.
+type HistogramSettings struct {
+	// Buckets are the upper bounds of the buckets of a histogram, in
+	// increasing order. Values above the last bound fall in an extra
+	// bucket.
+	Buckets []float64 `yaml:"buckets,omitempty"`
+	// Scale sets the buckets of an exponential histogram: bucket i
+	// holds the values in (base^i, base^(i+1)] with base 2^(2^-scale).
+	// Defaults to 0, where buckets double in size. Values too far apart
+	// for maxExponentialBuckets buckets are counted at a lower scale.
+	Scale int32    `yaml:"scale,omitempty"`
+	By    []string `yaml:"by,omitempty"`
+}
+
+// validate checks the settings of a histogram metric of metricType
+// labelled labelNames.
+func (h *HistogramSettings) validate(metricType string, labelNames []string) error {
+	switch metricType {
+	case MetricTypeHistogram:
+		if len(h.Buckets) == 0 {
+			return errors.New("histogram needs buckets")
+		}
+		for i := 1; i < len(h.Buckets); i++ {
+			if h.Buckets[i] <= h.Buckets[i-1] {
+				return fmt.Errorf("histogram buckets %v are not increasing", h.Buckets)
+			}
+		}
+	case MetricTypeExponentialHistogram:
+		if h.Scale < minExponentialScale || h.Scale > maxExponentialScale {
+			return fmt.Errorf("invalid exponential histogram scale %d, want %d to %d", h.Scale, minExponentialScale, maxExponentialScale)
+		}
+	}
+	for _, by := range h.By {
+		if labelIndex(labelNames, by) < 0 {
+			return fmt.Errorf("histogram by unknown label %s", by)
+		}
+	}
+	return nil
+}
+
+// valued reports whether p has a value a histogram can count, which
+// excludes the NaN of the nan onMissing policy.
+func (p dataPoint) valued() bool {
+	return !p.noValue && !math.IsNaN(p.value)
+}
+
+// setHistogram sets dp to the histogram of the points with a value.
+func (h *HistogramSettings) setHistogram(dp pmetric.HistogramDataPoint, points []dataPoint) {
+	dp.ExplicitBounds().FromRaw(h.Buckets)
+	counts := make([]uint64, len(h.Buckets)+1)
+	min, max, sum, n := summarize(points)
+	for _, p := range points {
+		if p.valued() {
+			counts[sort.SearchFloat64s(h.Buckets, p.value)]++
+		}
+	}
+	dp.BucketCounts().FromRaw(counts)
+	dp.SetCount(n)
+	dp.SetSum(sum)
+	if n > 0 {
+		dp.SetMin(min)
+		dp.SetMax(max)
+	}
+}
+
+// setExponentialHistogram sets dp to the exponential histogram of the
+// points with a value.
+func (h *HistogramSettings) setExponentialHistogram(dp pmetric.ExponentialHistogramDataPoint, points []dataPoint) {
+	var positive, negative []int32
+	var zero uint64
+	for _, p := range points {
+		switch {
+		case !p.valued():
+		case p.value > 0:
+			positive = append(positive, bucketIndex(p.value, h.Scale))
+		case p.value < 0:
+			negative = append(negative, bucketIndex(-p.value, h.Scale))
+		default:
+			zero++
+		}
+	}
+	// Every step down the scale merges the buckets by pairs.
+	scale := h.Scale
+	for scale > minExponentialScale && (bucketSpan(positive) > maxExponentialBuckets || bucketSpan(negative) > maxExponentialBuckets) {
+		for _, idx := range [][]int32{positive, negative} {
+			for i := range idx {
+				idx[i] >>= 1
+			}
+		}
+		scale--
+	}
+	dp.SetScale(scale)
+	setBuckets(dp.Positive(), positive)
+	setBuckets(dp.Negative(), negative)
+	dp.SetZeroCount(zero)
+
+	min, max, sum, n := summarize(points)
+	dp.SetCount(n)
+	dp.SetSum(sum)
+	if n > 0 {
+		dp.SetMin(min)
+		dp.SetMax(max)
+	}
+}
+
+// bucketIndex returns the index of the exponential histogram bucket of
+// the positive value v at scale.
+func bucketIndex(v float64, scale int32) int32 {
+	return int32(math.Ceil(math.Log2(v)*math.Ldexp(1, int(scale)))) - 1
+}
+
+// bucketRange returns the lowest and the highest of the bucket indexes
+// idx, which must not be empty.
+func bucketRange(idx []int32) (lo, hi int32) {
+	lo, hi = idx[0], idx[0]
+	for _, i := range idx {
+		if i < lo {
+			lo = i
+		}
+		if i > hi {
+			hi = i
+		}
+	}
+	return lo, hi
+}
+
+// bucketSpan returns the number of buckets needed to count the bucket
+// indexes idx.
+func bucketSpan(idx []int32) int64 {
+	if len(idx) == 0 {
+		return 0
+	}
+	lo, hi := bucketRange(idx)
+	return int64(hi) - int64(lo) + 1
+}
+
+// setBuckets sets b to count the bucket indexes idx.
+func setBuckets(b pmetric.ExponentialHistogramDataPointBuckets, idx []int32) {
+	if len(idx) == 0 {
+		return
+	}
+	lo, hi := bucketRange(idx)
+	counts := make([]uint64, hi-lo+1)
+	for _, i := range idx {
+		counts[i-lo]++
+	}
+	b.SetOffset(lo)
+	b.BucketCounts().FromRaw(counts)
+}
+
+// summarize returns the minimum, maximum, sum and number of the points
+// with a value.
+func summarize(points []dataPoint) (min, max, sum float64, n uint64) {
+	for _, p := range points {
+		if !p.valued() {
+			continue
+		}
+		if n == 0 || p.value < min {
+			min = p.value
+		}
+		if n == 0 || p.value > max {
+			max = p.value
+		}
+		sum += p.value
+		n++
+	}
+	return min, max, sum, n
+}
@@ -0,0 +1,190 @@
+package k8sresmetric
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/collector/pdata/pmetric"
+	"go.uber.org/zap"
+)
+
+func TestCollectHistogram(t *testing.T) {
+	f := &fakeCollector{
+		labels: map[string][]string{"sizes": {"name", "level"}},
+		results: map[string]Result{
+			"sizes": {
+				Vals: []interface{}{"1Gi", "100Gi", nil, "2Ti", float64(512)},
+				LabelValues: [][]string{
+					{"vol1", "premium"},
+					{"vol2", "premium"},
+					{"vol3", "premium"},
+					{"vol4", "premium"},
+					{"vol5", "standard"},
+				},
+			},
+		},
+	}
+	m := MetricsConfig{Name: "sizes", MetricType: MetricTypeHistogram}
+	m.Properties.Histogram = &HistogramSettings{Buckets: []float64{1 << 30, 1 << 40}, By: []string{"level"}}
+
+	c := newTestCollector(t, f, m)
+	// Refresh on the first scrape only.
+	c.lastScrapeTime = time.Time{}
+	hist := c.Collect(context.Background()).ResourceMetrics().At(0).ScopeMetrics().At(0).Metrics().At(0).Histogram()
+	assert.Equal(t, pmetric.AggregationTemporalityCumulative, hist.AggregationTemporality())
+	dps := hist.DataPoints()
+	assert.Equal(t, 2, dps.Len())
+	start := dps.At(0).StartTimestamp()
+	assert.NotZero(t, start)
+	assert.LessOrEqual(t, start, dps.At(0).Timestamp())
+
+	dp := dps.At(0)
+	assert.Equal(t, map[string]interface{}{"level": "premium"}, dp.Attributes().AsRaw())
+	assert.Equal(t, []float64{1 << 30, 1 << 40}, dp.ExplicitBounds().AsRaw())
+	assert.Equal(t, []uint64{1, 1, 1}, dp.BucketCounts().AsRaw())
+	assert.Equal(t, uint64(3), dp.Count())
+	assert.Equal(t, float64(1<<30+100<<30+2<<40), dp.Sum())
+	assert.Equal(t, float64(1<<30), dp.Min())
+	assert.Equal(t, float64(2<<40), dp.Max())
+
+	dp = dps.At(1)
+	assert.Equal(t, map[string]interface{}{"level": "standard"}, dp.Attributes().AsRaw())
+	assert.Equal(t, []uint64{1, 0, 0}, dp.BucketCounts().AsRaw())
+
+	// The standard volumes go away.
+	f.results["sizes"] = Result{Vals: []interface{}{nil}, LabelValues: [][]string{{"vol3", "premium"}}}
+	dps = c.Collect(context.Background()).ResourceMetrics().At(0).ScopeMetrics().At(0).Metrics().At(0).Histogram().DataPoints()
+	assert.Equal(t, 2, dps.Len())
+	// The histograms start at the refresh they describe.
+	assert.Equal(t, start, dps.At(0).StartTimestamp())
+	assert.Equal(t, start, dps.At(1).StartTimestamp())
+	assert.LessOrEqual(t, start, dps.At(0).Timestamp())
+	assert.Equal(t, uint64(0), dps.At(0).Count())
+	assert.False(t, dps.At(0).HasMin())
+	assert.False(t, dps.At(0).Flags().NoRecordedValue())
+	assert.True(t, dps.At(1).Flags().NoRecordedValue())
+	assert.Equal(t, map[string]interface{}{"level": "standard"}, dps.At(1).Attributes().AsRaw())
+}
+
+func TestCollectExponentialHistogram(t *testing.T) {
+	f := &fakeCollector{
+		labels: map[string][]string{"ages": {"name"}},
+		results: map[string]Result{
+			"ages": {
+				Vals:        []interface{}{float64(1), float64(2), float64(3), float64(4), float64(5), float64(0), float64(-3), nil},
+				LabelValues: [][]string{{"a"}, {"b"}, {"c"}, {"d"}, {"e"}, {"f"}, {"g"}, {"h"}},
+			},
+		},
+	}
+	m := MetricsConfig{Name: "ages", MetricType: MetricTypeExponentialHistogram}
+	m.Properties.Histogram = &HistogramSettings{}
+	m.Properties.OnMissing = OnMissingNaN
+
+	c := newTestCollector(t, f, m)
+	hist := c.Collect(context.Background()).ResourceMetrics().At(0).ScopeMetrics().At(0).Metrics().At(0).ExponentialHistogram()
+	assert.Equal(t, pmetric.AggregationTemporalityCumulative, hist.AggregationTemporality())
+	dps := hist.DataPoints()
+	assert.Equal(t, 1, dps.Len())
+	dp := dps.At(0)
+	assert.NotZero(t, dp.StartTimestamp())
+	assert.Equal(t, map[string]interface{}{}, dp.Attributes().AsRaw())
+	assert.Equal(t, int32(0), dp.Scale())
+	// (0.5, 1], (1, 2], (2, 4] and (4, 8]. The missing value is NaN and
+	// is not counted.
+	assert.Equal(t, int32(-1), dp.Positive().Offset())
+	assert.Equal(t, []uint64{1, 1, 2, 1}, dp.Positive().BucketCounts().AsRaw())
+	assert.Equal(t, int32(1), dp.Negative().Offset())
+	assert.Equal(t, []uint64{1}, dp.Negative().BucketCounts().AsRaw())
+	assert.Equal(t, uint64(1), dp.ZeroCount())
+	assert.Equal(t, uint64(7), dp.Count())
+	assert.Equal(t, float64(12), dp.Sum())
+	assert.Equal(t, float64(-3), dp.Min())
+	assert.Equal(t, float64(5), dp.Max())
+}
+
+func TestExponentialHistogramDownscale(t *testing.T) {
+	h := &HistogramSettings{Scale: maxExponentialScale}
+	dp := pmetric.NewExponentialHistogramDataPoint()
+	h.setExponentialHistogram(dp, []dataPoint{{value: 1}, {value: 1e6}, {value: 1e12}, {value: -1}})
+
+	// 1 to 1e12 would need about 42 million buckets at scale 20, and
+	// fit in 160 from scale 1.
+	assert.Equal(t, int32(1), dp.Scale())
+	assert.Equal(t, bucketIndex(1, 1), dp.Positive().Offset())
+	counts := dp.Positive().BucketCounts().AsRaw()
+	assert.LessOrEqual(t, len(counts), maxExponentialBuckets)
+	assert.Equal(t, int(bucketIndex(1e12, 1)-bucketIndex(1, 1))+1, len(counts))
+	assert.Equal(t, uint64(1), counts[bucketIndex(1e6, 1)-bucketIndex(1, 1)])
+	assert.Equal(t, []uint64{1}, dp.Negative().BucketCounts().AsRaw())
+	assert.Equal(t, uint64(4), dp.Count())
+}
+
+func TestBucketIndex(t *testing.T) {
+	for _, tc := range []struct {
+		v     float64
+		scale int32
+		want  int32
+	}{
+		{1, 0, -1},
+		{1.5, 0, 0},
+		{2, 0, 0},
+		{1024, 0, 9},
+		{1025, 0, 10},
+		{0.25, 0, -3},
+		{2, 1, 1},
+		{3, 1, 3},
+		{16, -1, 1},
+		{17, -1, 2},
+	} {
+		assert.Equal(t, tc.want, bucketIndex(tc.v, tc.scale), fmt.Sprintf("%v at scale %d", tc.v, tc.scale))
+	}
+}
+
+func TestHistogramValidate(t *testing.T) {
+	labels := []string{"name", "level"}
+	assert.Nil(t, (&HistogramSettings{Buckets: []float64{1, 10}, By: []string{"level"}}).validate(MetricTypeHistogram, labels))
+	assert.Nil(t, (&HistogramSettings{}).validate(MetricTypeExponentialHistogram, labels))
+	assert.EqualError(t, (&HistogramSettings{}).validate(MetricTypeHistogram, labels), "histogram needs buckets")
+	assert.EqualError(t, (&HistogramSettings{Buckets: []float64{10, 1}}).validate(MetricTypeHistogram, labels), "histogram buckets [10 1] are not increasing")
+	assert.EqualError(t, (&HistogramSettings{Scale: 21}).validate(MetricTypeExponentialHistogram, labels), "invalid exponential histogram scale 21, want -10 to 20")
+	assert.EqualError(t, (&HistogramSettings{Scale: 2, By: []string{"zone"}}).validate(MetricTypeExponentialHistogram, labels), "histogram by unknown label zone")
+}
+
+func TestSetCollectorsHistogram(t *testing.T) {
+	config := `
+metrics:
+  - name: ntap_volume_size
+    type: %s
+    properties:
+      type: kubernetes
+      object: NetAppVolume
+      value: $.spec.size
+      labels:
+        level: $.spec.serviceLevel
+      histogram:
+        buckets: [1e9, 1e12]
+        by: [level]
+`
+	tel, _ := newTestTelemetry(t)
+	c, err := SetCollectors(fmt.Sprintf(config, MetricTypeHistogram), nil, ListSettings{}, zap.NewNop(), tel)
+	assert.Nil(t, err)
+	assert.Equal(t, &HistogramSettings{Buckets: []float64{1e9, 1e12}, By: []string{"level"}}, c[0].histograms["ntap_volume_size"])
+
+	c, err = SetCollectors(fmt.Sprintf(config, "gauge"), nil, ListSettings{}, zap.NewNop(), tel)
+	assert.Nil(t, err)
+	assert.Empty(t, c[0].histograms)
+
+	_, err = SetCollectors(`
+metrics:
+  - name: ntap_volume_size
+    type: histogram
+    properties:
+      type: kubernetes
+      object: NetAppVolume
+      value: $.spec.size
+`, nil, ListSettings{}, zap.NewNop(), tel)
+	assert.EqualError(t, err, "metric ntap_volume_size: histogram needs buckets")
+}
@@ -0,0 +1,252 @@
+package k8sresmetric
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/spyzhov/ajson"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Ways of finding the related object of a join.
+const (
+	// JoinByNamespace relates the object in the same namespace.
+	JoinByNamespace = "namespace"
+	// JoinByOwner relates an owner of the object.
+	JoinByOwner = "owner"
+	// JoinByName relates the object named by the value of path.
+	JoinByName = "name"
+	// JoinByField relates the object whose value of field equals the
+	// value of path.
+	JoinByField = "field"
+)
+
+// JoinConfig looks up, for every object of a metric, a related object
+// whose fields the label paths of the metric can read. Related objects
+// are looked up in the namespace of the object, unless their kind is
+// cluster scoped.
+type JoinConfig struct {
+	// Object names the kind of the related objects, as the object of
+	// the metric does.
+	Object string `yaml:"object"`
+	// By is namespace, owner, name or field.
+	By string `yaml:"by"`
+	// Path selects, on the object, the name of the related object or
+	// the value its field must equal.
+	Path string `yaml:"path,omitempty"`
+	// Field selects the field of the related objects compared with the
+	// value of Path.
+	Field string `yaml:"field,omitempty"`
+}
+
+// join finds the related objects of the objects of a metric.
+type join struct {
+	name string
+	Obj  string
+	by   string
+	// path is the path on the object and field the path on the related
+	// objects.
+	path  *pathReader
+	field *pathReader
+	// metadataOnly is set when only the metadata of the related objects
+	// is read.
+	metadataOnly bool
+	// index maps the keys of the related objects listed by the last
+	// refresh to the objects.
+	index map[string]*object
+}
+
+// compileJoin checks the join named name and parses its paths.
+func compileJoin(name string, c JoinConfig) (*join, error) {
+	if c.Object == "" {
+		return nil, fmt.Errorf("join %s has no object", name)
+	}
+	j := &join{name: name, Obj: c.Object, by: c.By, metadataOnly: true}
+	switch c.By {
+	case JoinByNamespace, JoinByOwner:
+		if c.Path != "" || c.Field != "" {
+			return nil, fmt.Errorf("join %s by %s takes no path or field", name, c.By)
+		}
+		return j, nil
+	case JoinByName:
+		if c.Field != "" {
+			return nil, fmt.Errorf("join %s by name takes no field", name)
+		}
+	case JoinByField:
+		if !strings.HasPrefix(c.Field, "$") {
+			return nil, fmt.Errorf("join %s by field needs a field starting with $", name)
+		}
+		p, err := compileJSONPath(c.Field)
+		if err != nil {
+			return nil, fmt.Errorf("invalid field %q of join %s: %w", c.Field, name, err)
+		}
+		j.field = &pathReader{path: p}
+		j.metadataOnly = metadataPath(c.Field)
+	default:
+		return nil, fmt.Errorf("invalid by %q of join %s, want namespace, owner, name or field", c.By, name)
+	}
+	if !strings.HasPrefix(c.Path, "$") {
+		return nil, fmt.Errorf("join %s by %s needs a path starting with $", name, c.By)
+	}
+	p, err := compileJSONPath(c.Path)
+	if err != nil {
+		return nil, fmt.Errorf("invalid path %q of join %s: %w", c.Path, name, err)
+	}
+	j.path = &pathReader{path: p}
+	return j, nil
+}
+
+// setObjects indexes the related objects.
+func (j *join) setObjects(objs []*object) {
+	j.index = make(map[string]*object, len(objs))
+	add := func(key string, obj *object) {
+		if _, ok := j.index[key]; !ok {
+			j.index[key] = obj
+		}
+	}
+	for _, obj := range objs {
+		m, err := objectMeta(obj)
+		if err != nil {
+			continue
+		}
+		switch j.by {
+		case JoinByNamespace:
+			add(m.GetNamespace(), obj)
+		case JoinByOwner:
+			add(string(m.GetUID()), obj)
+		case JoinByName:
+			add(m.GetNamespace()+"/"+m.GetName(), obj)
+		case JoinByField:
+			v, err := j.field.Value(obj)
+			if err != nil {
+				continue
+			}
+			for _, key := range joinKeys(v) {
+				add(m.GetNamespace()+"/"+key, obj)
+			}
+		}
+	}
+}
+
+// lookup returns the object related to obj, or nil when there is none.
+func (j *join) lookup(obj *object) (*object, error) {
+	m, err := objectMeta(obj)
+	if err != nil {
+		return nil, err
+	}
+	if j.by == JoinByOwner {
+		for _, ref := range m.GetOwnerReferences() {
+			if related, ok := j.index[string(ref.UID)]; ok {
+				return related, nil
+			}
+		}
+		return nil, nil
+	}
+
+	var keys []string
+	if j.by == JoinByNamespace {
+		keys = []string{""}
+	} else {
+		v, err := j.path.Value(obj)
+		if err != nil {
+			return nil, err
+		}
+		keys = joinKeys(v)
+	}
+	for _, key := range keys {
+		// Related objects in the namespace of obj come first, then
+		// cluster scoped ones.
+		for _, ns := range []string{m.GetNamespace(), ""} {
+			k := ns + "/" + key
+			if j.by == JoinByNamespace {
+				k = ns
+			}
+			if related, ok := j.index[k]; ok {
+				return related, nil
+			}
+		}
+	}
+	return nil, nil
+}
+
+// joinKeys returns the keys a resolved path value is joined on.
+func joinKeys(v interface{}) []string {
+	var vals []interface{}
+	switch t := v.(type) {
+	case nil:
+		return nil
+	case []*ajson.Node:
+		vals, _ = nodeValues(t)
+	case []interface{}:
+		vals = t
+	default:
+		vals = []interface{}{t}
+	}
+	keys := make([]string, 0, len(vals))
+	for _, val := range vals {
+		switch t := val.(type) {
+		case nil:
+		case string:
+			keys = append(keys, t)
+		default:
+			keys = append(keys, fmt.Sprint(t))
+		}
+	}
+	return keys
+}
+
+// objectMeta returns the metadata of obj.
+func objectMeta(obj *object) (metav1.Object, error) {
+	if obj.typed != nil {
+		return meta.Accessor(obj.typed)
+	}
+	node, err := obj.JSON()
+	if err != nil {
+		return nil, err
+	}
+	m := &metav1.ObjectMeta{}
+	if !node.IsObject() || !node.HasKey("metadata") {
+		return m, nil
+	}
+	metadata, err := node.GetKey("metadata")
+	if err != nil {
+		return nil, err
+	}
+	b, err := ajson.Marshal(metadata)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// pathReader resolves a path against objects, through an accessor for
+// typed objects.
+type pathReader struct {
+	path *jsonPath
+	// acc is the accessor of the path compiled for objects of type t.
+	t   reflect.Type
+	acc *accessor
+}
+
+// Value resolves the path against obj.
+func (r *pathReader) Value(obj *object) (interface{}, error) {
+	if obj.typed != nil {
+		if t := reflect.TypeOf(obj.typed); t != r.t {
+			r.t, r.acc = t, compileAccessor(t, r.path.path)
+		}
+		if r.acc != nil {
+			return r.acc.Get(obj.typed), nil
+		}
+	}
+	node, err := obj.JSON()
+	if err != nil {
+		return nil, err
+	}
+	return r.path.Value(node)
+}
@@ -0,0 +1,182 @@
+package k8sresmetric
+
+import (
+	"context"
+	"testing"
+
+	"github.com/spyzhov/ajson"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	quarkv1alpha1 "quark.netapp.io/otel-controller/internal/quark/v1alpha1"
+)
+
+func TestUpdateJoin(t *testing.T) {
+	vol := func(ns, uuid, name string) *quarkv1alpha1.NetAppVolume {
+		v := &quarkv1alpha1.NetAppVolume{ObjectMeta: metav1.ObjectMeta{Name: "vol", Namespace: ns, UID: types.UID("uid-" + uuid)}}
+		v.Spec.DisplayName = name
+		v.Spec.ServiceLevel = "premium"
+		v.Status.VolumeUUID = uuid
+		return v
+	}
+	rep := func(name, uuid string, owners ...metav1.OwnerReference) *quarkv1alpha1.NetAppVolumeReplication {
+		r := &quarkv1alpha1.NetAppVolumeReplication{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "ns", OwnerReferences: owners}}
+		r.Spec.DestinationVolume.VolumeUUID = uuid
+		r.Status.LastTransferSize = 4096
+		return r
+	}
+	level := &quarkv1alpha1.NetAppServiceLevel{ObjectMeta: metav1.ObjectMeta{Name: "premium", Namespace: "ns"}}
+	level.Spec.QuarkVersion = "1.2"
+	defer setTestClient(t,
+		vol("ns", "a", "Volume A"),
+		vol("other", "b", "Volume B"),
+		level,
+		&quarkv1alpha1.Quark{ObjectMeta: metav1.ObjectMeta{Name: "quark", Namespace: "ns"}},
+		rep("rep-a", "a", metav1.OwnerReference{Kind: "NetAppVolume", Name: "vol", UID: "uid-a"}),
+		// The volume of rep-b is in another namespace.
+		rep("rep-b", "b"),
+	)()
+
+	tel, _ := newTestTelemetry(t)
+	km := &kMetrics{metricsMap: make(map[string]*MetricsInfo), logger: zap.NewNop(), telemetry: tel}
+	m := MetricsConfig{Name: "transfer"}
+	m.Properties.Object = "NetAppVolumeReplication"
+	m.Properties.Value = "$.status.lastTransferSize"
+	m.Properties.Join = map[string]JoinConfig{
+		"volume": {Object: "NetAppVolume", By: JoinByField, Field: "$.status.VolumeUUID", Path: "$.spec.destinationVolume.volumeUUID"},
+		"owner":  {Object: "NetAppVolume", By: JoinByOwner},
+		"quark":  {Object: "Quark", By: JoinByNamespace},
+	}
+	m.Properties.Labels = map[string]string{
+		"name":         "$.metadata.name",
+		"volume":       "volume:$.spec.displayName",
+		"level":        "volume:$.spec.serviceLevel",
+		"owner":        "owner:$.metadata.uid",
+		"quark":        "quark:$.metadata.name",
+		"region":       "eu:west",
+		"missing_join": "other:$.metadata.name",
+	}
+	assert.Nil(t, km.RegisterMetric(m))
+	// The join of the volume reads the spec.
+	assert.Equal(t, []bool{true, true, false}, []bool{km.metricsMap["transfer"].joins[0].metadataOnly, km.metricsMap["transfer"].joins[1].metadataOnly, km.metricsMap["transfer"].joins[2].metadataOnly})
+
+	m = MetricsConfig{Name: "size"}
+	m.Properties.Object = "NetAppVolume"
+	m.Properties.Join = map[string]JoinConfig{
+		"level": {Object: "NetAppServiceLevel", By: JoinByName, Path: "$.spec.serviceLevel"},
+	}
+	m.Properties.Labels = map[string]string{"name": "$.spec.displayName", "quark_version": "level:$.spec.quarkVersion"}
+	m.Properties.Value = "$.status.size"
+	assert.Nil(t, km.RegisterMetric(m))
+
+	assert.Nil(t, km.Update(context.Background()))
+	r, err := km.Values("transfer")
+	assert.Nil(t, err)
+	labels := km.LabelNames("transfer")
+	var got []map[string]string
+	for _, lv := range r.LabelValues {
+		l := make(map[string]string)
+		for i, v := range lv {
+			l[labels[i]] = v
+		}
+		got = append(got, l)
+	}
+	assert.ElementsMatch(t, []map[string]string{
+		{"name": "rep-a", "volume": "Volume A", "level": "premium", "owner": "uid-a", "quark": "quark", "region": "eu:west", "missing_join": "other:$.metadata.name"},
+		{"name": "rep-b", "volume": "", "level": "", "owner": "", "quark": "quark", "region": "eu:west", "missing_join": "other:$.metadata.name"},
+	}, got)
+
+	r, err = km.Values("size")
+	assert.Nil(t, err)
+	labels = km.LabelNames("size")
+	got = nil
+	for _, lv := range r.LabelValues {
+		l := make(map[string]string)
+		for i, v := range lv {
+			l[labels[i]] = v
+		}
+		got = append(got, l)
+	}
+	// There is no service level in the namespace of volume B.
+	assert.ElementsMatch(t, []map[string]string{
+		{"name": "Volume A", "quark_version": "1.2"},
+		{"name": "Volume B", "quark_version": ""},
+	}, got)
+}
+
+func TestJoinLookup(t *testing.T) {
+	objs := jsonObjects(parseJSON(t,
+		`{"metadata": {"name": "a", "namespace": "ns", "uid": "1"}, "spec": {"uuid": "x", "tags": ["t1", "t2"]}}`,
+		`{"metadata": {"name": "a", "namespace": "other", "uid": "2"}, "spec": {"uuid": "y"}}`,
+		`{"metadata": {"name": "b", "uid": "3"}, "spec": {"uuid": 7}}`,
+	)...)
+
+	for _, tc := range []struct {
+		join JoinConfig
+		obj  string
+		want string
+	}{
+		{JoinConfig{By: JoinByNamespace}, `{"metadata": {"namespace": "ns"}}`, "1"},
+		{JoinConfig{By: JoinByNamespace}, `{"metadata": {"namespace": "none"}}`, "3"},
+		{JoinConfig{By: JoinByOwner}, `{"metadata": {"ownerReferences": [{"uid": "0"}, {"uid": "2"}]}}`, "2"},
+		{JoinConfig{By: JoinByOwner}, `{"metadata": {}}`, ""},
+		{JoinConfig{By: JoinByName, Path: "$.spec.ref"}, `{"metadata": {"namespace": "other"}, "spec": {"ref": "a"}}`, "2"},
+		{JoinConfig{By: JoinByName, Path: "$.spec.ref"}, `{"metadata": {"namespace": "ns"}, "spec": {"ref": "b"}}`, "3"},
+		{JoinConfig{By: JoinByName, Path: "$.spec.ref"}, `{"metadata": {"namespace": "none"}, "spec": {"ref": "a"}}`, ""},
+		{JoinConfig{By: JoinByName, Path: "$.spec.ref"}, `{"metadata": {"namespace": "ns"}}`, ""},
+		{JoinConfig{By: JoinByField, Field: "$.spec.uuid", Path: "$.spec.ref"}, `{"metadata": {"namespace": "ns"}, "spec": {"ref": "x"}}`, "1"},
+		{JoinConfig{By: JoinByField, Field: "$.spec.uuid", Path: "$.spec.ref"}, `{"metadata": {"namespace": "ns"}, "spec": {"ref": "y"}}`, ""},
+		{JoinConfig{By: JoinByField, Field: "$.spec.uuid", Path: "$.spec.ref"}, `{"metadata": {"namespace": "ns"}, "spec": {"ref": 7}}`, "3"},
+		{JoinConfig{By: JoinByField, Field: "$.spec.tags[*]", Path: "$.spec.ref"}, `{"metadata": {"namespace": "ns"}, "spec": {"ref": "t2"}}`, "1"},
+	} {
+		tc.join.Object = "Related"
+		j, err := compileJoin("related", tc.join)
+		if !assert.Nil(t, err, tc.join) {
+			continue
+		}
+		j.setObjects(objs)
+		related, err := j.lookup(jsonObjects(parseJSON(t, tc.obj)...)[0])
+		assert.Nil(t, err, tc.obj)
+		uid := ""
+		if related != nil {
+			m, err := objectMeta(related)
+			assert.Nil(t, err)
+			uid = string(m.GetUID())
+		}
+		assert.Equal(t, tc.want, uid, "%s by %s", tc.obj, tc.join.By)
+	}
+}
+
+// parseJSON parses every document of docs.
+func parseJSON(t *testing.T, docs ...string) []*ajson.Node {
+	var nodes []*ajson.Node
+	for _, d := range docs {
+		n, err := ajson.Unmarshal([]byte(d))
+		assert.Nil(t, err)
+		nodes = append(nodes, n)
+	}
+	return nodes
+}
+
+func TestCompileJoin(t *testing.T) {
+	for c, want := range map[JoinConfig]string{
+		{By: JoinByOwner}:                                            "join j has no object",
+		{Object: "Quark", By: "label"}:                               `invalid by "label" of join j, want namespace, owner, name or field`,
+		{Object: "Quark", By: JoinByOwner, Path: "$.a"}:              "join j by owner takes no path or field",
+		{Object: "Quark", By: JoinByName}:                            "join j by name needs a path starting with $",
+		{Object: "Quark", By: JoinByName, Path: "$.a", Field: "$.b"}: "join j by name takes no field",
+		{Object: "Quark", By: JoinByField, Path: "$.a"}:              "join j by field needs a field starting with $",
+	} {
+		_, err := compileJoin("j", c)
+		assert.EqualError(t, err, want)
+	}
+	_, err := compileJoin("j", JoinConfig{Object: "Quark", By: JoinByField, Path: "$.a", Field: "$[?(@.a ==)]"})
+	assert.ErrorContains(t, err, `invalid field "$[?(@.a ==)]" of join j`)
+
+	km := &kMetrics{metricsMap: make(map[string]*MetricsInfo)}
+	m := MetricsConfig{Name: "m"}
+	m.Properties.Value = "$.a"
+	m.Properties.Join = map[string]JoinConfig{"j": {By: JoinByOwner}}
+	assert.EqualError(t, km.RegisterMetric(m), "metric m: join j has no object")
+}
@@ -0,0 +1,170 @@
+package k8sresmetric
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/spyzhov/ajson"
+)
+
+// jsonPath is a value or label path parsed once, when the metric is
+// registered, instead of on every evaluation.
+type jsonPath struct {
+	path string
+	// commands is the parsed path. It is nil for expressions that are
+	// not a path, such as constants, which are evaluated as is.
+	commands []string
+	// keys is set for paths ending in ~, which select the keys of the
+	// nodes rather than their values.
+	keys bool
+	// names is set for paths ending in a union of keys, such as
+	// ['a','b']. Every name selects a node, a null one when the object
+	// lacks the key, so that missing keys are reported too.
+	names []string
+}
+
+// compileJSONPath parses path and checks the syntax of its filter and
+// script expressions.
+func compileJSONPath(path string) (*jsonPath, error) {
+	p := &jsonPath{path: path}
+	expr := path
+	if keyPath, ok := strings.CutSuffix(path, "~"); ok {
+		p.keys = true
+		expr = keyPath
+	}
+
+	if !strings.HasPrefix(expr, "$") {
+		if p.keys {
+			return nil, errors.New("keys can only be selected by a path starting with $")
+		}
+		if err := checkSyntax(expr); err != nil {
+			return nil, err
+		}
+		return p, nil
+	}
+
+	commands, err := ajson.ParseJSONPath(expr)
+	if err != nil {
+		return nil, err
+	}
+	for _, c := range commands {
+		script, ok := strings.CutPrefix(c, "?")
+		if !ok && !strings.HasPrefix(c, "(") {
+			continue
+		}
+		if !strings.HasPrefix(script, "(") || !strings.HasSuffix(script, ")") {
+			return nil, fmt.Errorf("malformed expression %q", c)
+		}
+		if err := checkSyntax(script[1 : len(script)-1]); err != nil {
+			return nil, fmt.Errorf("expression %q: %w", c, err)
+		}
+	}
+	p.commands = commands
+	p.names = unionNames(commands[len(commands)-1])
+	return p, nil
+}
+
+// unionNames returns the names of a union of quoted keys, or nil if c is
+// not one.
+func unionNames(c string) []string {
+	parts := strings.Split(c, ",")
+	if len(parts) < 2 {
+		return nil
+	}
+	names := make([]string, 0, len(parts))
+	for _, part := range parts {
+		part = strings.TrimSpace(part)
+		if len(part) < 2 || (part[0] != '\'' && part[0] != '"') || part[len(part)-1] != part[0] {
+			return nil
+		}
+		names = append(names, part[1:len(part)-1])
+	}
+	return names
+}
+
+// checkSyntax evaluates expr against an empty object and returns the
+// errors caused by its syntax. Other errors, such as comparing values of
+// different types, depend on the object and are left to evaluation.
+func checkSyntax(expr string) error {
+	_, err := ajson.Eval(ajson.ObjectNode("", nil), expr)
+	var aErr ajson.Error
+	if !errors.As(err, &aErr) {
+		return err
+	}
+	switch aErr.Type {
+	case ajson.WrongSymbol, ajson.UnexpectedEOF:
+		return err
+	case ajson.WrongRequest:
+		if strings.HasPrefix(aErr.Message, "wrong request") || strings.Contains(aErr.Message, "formula") {
+			return err
+		}
+	}
+	return nil
+}
+
+// Nodes returns the nodes of root selected by the path.
+func (p *jsonPath) Nodes(root *ajson.Node) ([]*ajson.Node, error) {
+	if p.names == nil {
+		return ajson.ApplyJSONPath(root, p.commands)
+	}
+	parents, err := ajson.ApplyJSONPath(root, p.commands[:len(p.commands)-1])
+	if err != nil {
+		return nil, err
+	}
+	var nodes []*ajson.Node
+	for _, parent := range parents {
+		if !parent.IsObject() {
+			continue
+		}
+		for _, name := range p.names {
+			n, err := parent.GetKey(name)
+			if err != nil {
+				n = ajson.NullNode(name)
+			}
+			nodes = append(nodes, n)
+		}
+	}
+	return nodes, nil
+}
+
+// Value evaluates the path against root. A path selecting several nodes
+// gives a []*ajson.Node, a path selecting a single array an arrayValue
+// and a path selecting nothing gives nil. A path
+// ending in ~ gives the keys of the selected nodes, as a []interface{}
+// when there are several.
+func (p *jsonPath) Value(root *ajson.Node) (interface{}, error) {
+	if p.commands == nil {
+		v, err := ajson.Eval(root, p.path)
+		if err != nil {
+			return nil, err
+		}
+		return v.Value()
+	}
+
+	nodes, err := p.Nodes(root)
+	if err != nil {
+		return nil, err
+	}
+	if p.keys {
+		keys := make([]interface{}, 0, len(nodes))
+		for _, n := range nodes {
+			keys = append(keys, n.Key())
+		}
+		if len(keys) == 1 {
+			return keys[0], nil
+		}
+		return keys, nil
+	}
+	switch len(nodes) {
+	case 0:
+		return nil, nil
+	case 1:
+		if nodes[0].IsArray() {
+			vals, err := nodeValues(nodes[0].MustArray())
+			return arrayValue(vals), err
+		}
+		return nodes[0].Value()
+	}
+	return nodes, nil
+}
@@ -0,0 +1,177 @@
+package k8sresmetric
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/spyzhov/ajson"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	quarkv1alpha1 "quark.netapp.io/otel-controller/internal/quark/v1alpha1"
+)
+
+func TestCompileJSONPath(t *testing.T) {
+	for _, path := range []string{
+		"$.status.lastTransferSize",
+		"$.status.conditions[?(@.type=='Ready')].status",
+		"$.status.images[?(@ =~ ':')]",
+		"$.status.conditions.length",
+		"$.status.health.*~",
+		"$.status.health['api','db']~",
+		"$..name",
+		"1",
+	} {
+		_, err := compileJSONPath(path)
+		assert.Nil(t, err, path)
+	}
+
+	for _, path := range []string{
+		"$.value[",
+		"$.status.conditions[?(@.type == )]",
+		"$.status.conditions[?(@.type == 'Ready)]",
+		"$.status.conditions[?(@.type == 'Ready'",
+		"$.a[(@.length-]",
+		"1~",
+	} {
+		_, err := compileJSONPath(path)
+		assert.NotNil(t, err, path)
+	}
+
+	root, err := ajson.Unmarshal([]byte(`{"status": {"health": {"etcd": "ok", "api": "ok"}, "size": 3}}`))
+	assert.Nil(t, err)
+	for path, want := range map[string]interface{}{
+		"$.status.size":      3.0,
+		"$.status.missing":   nil,
+		"$.status.health.*~": []interface{}{"api", "etcd"},
+		"$.status.size~":     "size",
+		// Every key of a union is selected, present or not.
+		"$.status.health['api','db']~": []interface{}{"api", "db"},
+		"1":                            1.0,
+	} {
+		p, err := compileJSONPath(path)
+		assert.Nil(t, err, path)
+		v, err := p.Value(root)
+		assert.Nil(t, err, path)
+		assert.Equal(t, want, v, path)
+	}
+
+	p, err := compileJSONPath("$.status.health['api','db','etcd']")
+	assert.Nil(t, err)
+	v, err := p.Value(root)
+	assert.Nil(t, err)
+	vals, err := nodeValues(v.([]*ajson.Node))
+	assert.Nil(t, err)
+	assert.Equal(t, []interface{}{"ok", nil, "ok"}, vals)
+}
+
+func TestRegisterMetricInvalidPath(t *testing.T) {
+	km := &kMetrics{metricsMap: make(map[string]*MetricsInfo), logger: zap.NewNop()}
+
+	m := MetricsConfig{Name: "broken"}
+	m.Properties.Value = "$.status.conditions[?(@.type == )].status"
+	err := km.RegisterMetric(m)
+	assert.ErrorContains(t, err, `metric broken: invalid value path "$.status.conditions[?(@.type == )].status"`)
+
+	m.Properties.Value = "$.status.size"
+	m.Properties.Labels = map[string]string{"name": "$.metadata.name[", "cluster": "prod"}
+	err = km.RegisterMetric(m)
+	assert.ErrorContains(t, err, `metric broken: invalid path "$.metadata.name[" of label name`)
+}
+
+const benchObjects = 10000
+
+// benchReplications returns n synthetic NetAppVolumeReplication objects.
+func benchReplications(n int) []*quarkv1alpha1.NetAppVolumeReplication {
+	objs := make([]*quarkv1alpha1.NetAppVolumeReplication, 0, n)
+	for i := 0; i < n; i++ {
+		objs = append(objs, &quarkv1alpha1.NetAppVolumeReplication{
+			ObjectMeta: metav1.ObjectMeta{Name: fmt.Sprintf("rep-%d", i), Namespace: "ns"},
+			Spec: quarkv1alpha1.NetAppVolumeReplicationSpec{
+				SourceVolume: quarkv1alpha1.NetAppVolumeReplicationVolume{VolumeUUID: fmt.Sprintf("uuid-%d", i)},
+			},
+			Status: quarkv1alpha1.NetAppVolumeReplicationStatus{
+				LastTransferSize: int64(i),
+				Conditions: []quarkv1alpha1.NetAppVolumeReplicationCondition{
+					{Type: quarkv1alpha1.VolumeReplicationHealthy, Status: v1.ConditionTrue},
+				},
+			},
+		})
+	}
+	return objs
+}
+
+// BenchmarkValues resolves a metric over 10k objects: with paths parsed on
+// every evaluation as before, with the paths compiled at registration,
+// and with typed objects read through accessors.
+func BenchmarkValues(b *testing.B) {
+	for _, bm := range []struct {
+		name  string
+		value string
+	}{
+		{"field", "$.status.lastTransferSize"},
+		{"filter", "$.status.conditions[?(@.type=='Healthy')].status"},
+	} {
+		m := MetricsConfig{Name: "metric"}
+		m.Properties.Value = bm.value
+		m.Properties.Labels = map[string]string{
+			"name":      "$.metadata.name",
+			"namespace": "$.metadata.namespace",
+			"source":    "$.spec.sourceVolume.volumeUUID",
+		}
+
+		reps := benchReplications(benchObjects)
+		var nodes, typed []*object
+		for _, r := range reps {
+			buf, err := json.Marshal(r)
+			if err != nil {
+				b.Fatal(err)
+			}
+			n, err := ajson.Unmarshal(buf)
+			if err != nil {
+				b.Fatal(err)
+			}
+			nodes = append(nodes, jsonObject(n))
+			typed = append(typed, typedObject(r))
+		}
+
+		b.Run(bm.name+"/eval", func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				for _, o := range nodes {
+					v, err := ajson.Eval(o.node, m.Properties.Value)
+					if err != nil {
+						b.Fatal(err)
+					}
+					if _, err := v.Value(); err != nil {
+						b.Fatal(err)
+					}
+					for _, l := range m.Properties.Labels {
+						if _, err := ajson.Eval(o.node, l); err != nil {
+							b.Fatal(err)
+						}
+					}
+				}
+			}
+		})
+		for _, data := range []struct {
+			name string
+			objs []*object
+		}{{"compiled", nodes}, {"typed", typed}} {
+			b.Run(bm.name+"/"+data.name, func(b *testing.B) {
+				km := &kMetrics{metricsMap: make(map[string]*MetricsInfo), logger: zap.NewNop()}
+				if err := km.RegisterMetric(m); err != nil {
+					b.Fatal(err)
+				}
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					if _, err := km.evaluate(context.Background(), m.Name, data.objs); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		}
+	}
+}
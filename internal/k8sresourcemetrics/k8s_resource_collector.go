@@ -2,42 +2,219 @@ package k8sresmetric
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
 
-	log "github.com/sirupsen/logrus"
 	"github.com/spyzhov/ajson"
+	"go.uber.org/zap"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 )
 
 type MetricsInfo struct {
-	Data []*ajson.Node
 	Path string
+	// Expr, when set, computes the value from the bindings instead of
+	// Path.
+	Expr string
 	Obj  string
 	// Separate out Label keys and path
 	// so that we do not have to iterate over map
 	// and risk being inconsistent with key and values.
 	LabelKeys []string
 	LabelPath []string
+	// States holds the states of a state set metric.
+	States []string
+	// Info is set for info and count metrics, whose every selected
+	// node yields the value 1.
+	Info bool
+	// Unit and Reduce select the value kept for reduced metrics.
+	Unit   string
+	Reduce string
+
+	// valuePath and labelPaths are the value and label paths parsed at
+	// registration. Constant labels have a nil path. labelTransforms
+	// hold the functions the label values are piped through.
+	valuePath       *jsonPath
+	labelPaths      []*jsonPath
+	labelTransforms []labelTransform
+	// expr is Expr parsed, and bindingPaths the paths of the bindings
+	// named bindingNames, sorted.
+	expr         *expression
+	bindingNames []string
+	bindingPaths []*jsonPath
+	// joins are the joins of the metric sorted by name, and labelJoins
+	// the index in joins of the join every label path reads, or -1 for
+	// label paths reading the object itself. labelReaders read the
+	// label paths of joins from the related objects.
+	joins        []*join
+	labelJoins   []int
+	labelReaders []*pathReader
+	// selector restricts the objects to those matching a label
+	// selector. It is nil when all objects are used.
+	selector labels.Selector
+	// metadataOnly is set when every path of the metric reads the
+	// object metadata, so that the rest of the objects need not be
+	// listed.
+	metadataOnly bool
+
+	// The accessors of the value, label and binding paths, compiled
+	// for typed objects of accessorType. A nil accessor falls back to
+	// JSONPath.
+	accessorType     reflect.Type
+	valueAccessor    *accessor
+	labelAccessors   []*accessor
+	bindingAccessors []*accessor
+
+	// result and err are the metric resolved by the last refresh. Only
+	// the result is kept, not the objects it was resolved against.
+	result Result
+	err    error
+	// listFailures counts the refreshes in a row the objects of the
+	// metric could not be listed in.
+	listFailures int
 }
+
+// maxListFailures is the number of refreshes in a row a metric keeps its
+// last result for when its objects cannot be listed. Past it, its series
+// are dropped, and marked stale, as for a kind that no longer resolves.
+const maxListFailures = 3
+
+// clear drops the result of the metric.
+func (m *MetricsInfo) clear() {
+	m.result, m.err, m.listFailures = Result{}, nil, 0
+}
+
 type kMetrics struct {
 	metricsMap map[string]*MetricsInfo
+	// listSettings controls how the objects are listed.
+	listSettings ListSettings
+	logger       *zap.Logger
+	telemetry    *Telemetry
 }
 
 func (k *kMetrics) RegisterMetric(m MetricsConfig) error {
-	k.metricsMap[m.Name] = &MetricsInfo{
+	info := &MetricsInfo{
 		Obj:       m.Properties.Object,
 		Path:      m.Properties.Value,
 		LabelKeys: []string{},
-		Data:      []*ajson.Node{},
+		States:    m.Properties.States,
+		Info:      m.MetricType == MetricTypeInfo || m.MetricType == MetricTypeCount,
+		Unit:      m.Properties.Unit,
+		Reduce:    m.Properties.Reduce,
 	}
 
-	for key, path := range m.Properties.Labels {
-		k.metricsMap[m.Name].LabelKeys = append(k.metricsMap[m.Name].LabelKeys, key)
-		k.metricsMap[m.Name].LabelPath = append(k.metricsMap[m.Name].LabelPath, path)
+	if m.Properties.Selector != "" {
+		sel, err := labels.Parse(m.Properties.Selector)
+		if err != nil {
+			return fmt.Errorf("metric %s: invalid selector %q: %w", m.Name, m.Properties.Selector, err)
+		}
+		info.selector = sel
+	}
+	labelPaths := m.Properties.Labels
+	if m.MetricType == MetricTypeCount {
+		if m.Properties.Value != "" || m.Properties.Expr != "" || len(m.Properties.States) > 0 {
+			return fmt.Errorf("metric %s: count metrics take no value, expr or states", m.Name)
+		}
+		labelPaths = make(map[string]string, len(m.Properties.Labels)+len(m.Properties.GroupBy))
+		for key, path := range m.Properties.Labels {
+			labelPaths[key] = path
+		}
+		for key, path := range m.Properties.GroupBy {
+			if _, ok := labelPaths[key]; ok {
+				return fmt.Errorf("metric %s: label %s is both in labels and groupBy", m.Name, key)
+			}
+			labelPaths[key] = path
+		}
+	} else if len(m.Properties.GroupBy) > 0 {
+		return fmt.Errorf("metric %s: groupBy is only for count metrics", m.Name)
+	}
+	if m.Properties.Expr != "" {
+		if err := info.compileExpr(m); err != nil {
+			return err
+		}
+	} else if !info.Info || info.Path != "" {
+		p, err := compileJSONPath(info.Path)
+		if err != nil {
+			return fmt.Errorf("metric %s: invalid value path %q: %w", m.Name, info.Path, err)
+		}
+		info.valuePath = p
+	}
+	for name, c := range m.Properties.Join {
+		j, err := compileJoin(name, c)
+		if err != nil {
+			return fmt.Errorf("metric %s: %w", m.Name, err)
+		}
+		info.joins = append(info.joins, j)
+	}
+	sort.Slice(info.joins, func(i, j int) bool { return info.joins[i].name < info.joins[j].name })
+	for key, path := range labelPaths {
+		// Paths of the form <join>:$... read the related object of a
+		// join.
+		joined, expr := -1, path
+		if name, jPath, ok := strings.Cut(path, ":"); ok && strings.HasPrefix(jPath, "$") {
+			if joined = info.joinIndex(name); joined >= 0 {
+				expr = jPath
+			}
+		}
+		var p *jsonPath
+		var transform labelTransform
+		if strings.HasPrefix(expr, "$") {
+			var funcs []string
+			var err error
+			expr, funcs, err = splitLabelTransform(expr)
+			if err == nil {
+				transform, err = parseLabelTransform(funcs)
+			}
+			if err != nil {
+				return fmt.Errorf("metric %s: invalid functions of label %s: %w", m.Name, key, err)
+			}
+			p, err = compileJSONPath(expr)
+			if err != nil {
+				return fmt.Errorf("metric %s: invalid path %q of label %s: %w", m.Name, path, key, err)
+			}
+		}
+		var r *pathReader
+		if joined >= 0 {
+			r = &pathReader{path: p}
+			info.joins[joined].metadataOnly = info.joins[joined].metadataOnly && metadataPath(expr)
+		}
+		info.LabelKeys = append(info.LabelKeys, key)
+		info.LabelPath = append(info.LabelPath, path)
+		info.labelPaths = append(info.labelPaths, p)
+		info.labelTransforms = append(info.labelTransforms, transform)
+		info.labelJoins = append(info.labelJoins, joined)
+		info.labelReaders = append(info.labelReaders, r)
+	}
+	if len(m.Properties.States) > 0 {
+		info.LabelKeys = append(info.LabelKeys, "state")
+	}
+	info.metadataOnly = info.valuePath == nil || metadataPath(info.Path)
+	for i, p := range info.labelPaths {
+		if p != nil && info.labelJoins[i] < 0 && !metadataPath(p.path) {
+			info.metadataOnly = false
+		}
+	}
+	for _, j := range info.joins {
+		if j.path != nil && !metadataPath(j.path.path.path) {
+			info.metadataOnly = false
+		}
+	}
+	for _, p := range info.bindingPaths {
+		if !metadataPath(p.path) {
+			info.metadataOnly = false
+		}
 	}
 
+	k.metricsMap[m.Name] = info
 	return nil
 }
 
@@ -45,24 +222,235 @@ func (k *kMetrics) LabelNames(metric string) []string {
 	return k.metricsMap[metric].LabelKeys
 }
 
-func (k *kMetrics) Update() error {
-	var err error
-	for key, val := range k.metricsMap {
-		v, _ := getGVK(val.Obj)
+// joinIndex returns the index of the join named name, or -1.
+func (m *MetricsInfo) joinIndex(name string) int {
+	for i, j := range m.joins {
+		if j.name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// snapshotKey identifies the objects a metric is resolved against.
+// Metrics with the same key share the objects listed in a refresh.
+type snapshotKey struct {
+	gvk       schema.GroupVersionKind
+	namespace string
+	selector  string
+}
+
+// snapshotGroup holds the names of the metrics and the joins sharing a
+// snapshotKey.
+type snapshotGroup struct {
+	// obj is the object reference of the first metric or join.
+	obj      string
+	metrics  []string
+	joins    []*join
+	selector labels.Selector
+	// metadataOnly is set when all the metrics and joins only read
+	// metadata.
+	metadataOnly bool
+}
+
+func (k *kMetrics) Update(ctx context.Context) error {
+	if err := refreshGVKMap(discoveryInterval); err != nil {
+		k.logger.Warn("error discovering object kinds", zap.Error(err))
+	}
 
-		u := unstructured.UnstructuredList{}
-		u.SetGroupVersionKind(v)
-		// TODO: add label selector.
-		err = cl.List(context.Background(), &u, &client.ListOptions{Namespace: os.Getenv("NAMESPACE")})
+	namespace := os.Getenv("NAMESPACE")
+	// Objects are listed and parsed once per key, however many metrics
+	// read them.
+	groups := make(map[snapshotKey]*snapshotGroup)
+	for key, val := range k.metricsMap {
+		v, err := getGVK(val.Obj)
 		if err != nil {
-			fmt.Println(err.Error())
+			k.logger.Warn("error resolving object kind", zap.String("metric", key), zap.String("object", val.Obj), zap.Error(err))
+			// The kind may have been removed with its objects.
+			val.clear()
 			continue
 		}
-		var vals []*ajson.Node
-		// Iterate over resources present in the unstructed list.
-		for _, i := range u.Items {
+
+		sk := snapshotKey{gvk: v, namespace: namespace}
+		if val.selector != nil {
+			sk.selector = val.selector.String()
+		}
+		g, ok := groups[sk]
+		if !ok {
+			g = &snapshotGroup{obj: val.Obj, selector: val.selector, metadataOnly: true}
+			groups[sk] = g
+		}
+		g.metrics = append(g.metrics, key)
+		g.metadataOnly = g.metadataOnly && val.metadataOnly
+
+		// The related objects of joins are listed unfiltered.
+		for _, j := range val.joins {
+			jv, err := getGVK(j.Obj)
+			if err != nil {
+				k.logger.Warn("error resolving join object kind", zap.String("metric", key), zap.String("join", j.name), zap.String("object", j.Obj), zap.Error(err))
+				continue
+			}
+			jk := snapshotKey{gvk: jv, namespace: namespace}
+			g, ok := groups[jk]
+			if !ok {
+				g = &snapshotGroup{obj: j.Obj, metadataOnly: true}
+				groups[jk] = g
+			}
+			g.joins = append(g.joins, j)
+			g.metadataOnly = g.metadataOnly && j.metadataOnly
+		}
+	}
+
+	// The related objects of joins are listed first, kept for the
+	// lookups, and their metrics resolved once every join is set. The
+	// other metrics are resolved one page at a time, so that besides
+	// the objects of joins only one page of objects is held at once.
+	joined := make(map[*snapshotGroup][]*object)
+	for _, joins := range []bool{true, false} {
+		for sk, g := range groups {
+			if (len(g.joins) > 0) != joins {
+				continue
+			}
+			var objs []*object
+			r := newGroupResult(g)
+			err := k.list(ctx, sk, g.selector, g.metadataOnly, func(page []*object) {
+				if joins {
+					objs = append(objs, page...)
+				} else {
+					k.resolvePage(ctx, r, page)
+				}
+			})
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			// Metrics whose objects could not be listed keep the
+			// result of the last refresh, for a while.
+			if err != nil {
+				k.logger.Warn("error listing objects", zap.String("object", g.obj), zap.String("gvk", sk.gvk.String()), zap.String("namespace", sk.namespace), zap.String("selector", sk.selector), zap.Error(err))
+				for _, name := range g.metrics {
+					m := k.metricsMap[name]
+					if m.listFailures++; m.listFailures >= maxListFailures {
+						m.clear()
+					}
+				}
+				continue
+			}
+			for _, j := range g.joins {
+				j.setObjects(objs)
+			}
+			if joins {
+				joined[g] = objs
+				continue
+			}
+			k.setResults(r)
+		}
+		for g, objs := range joined {
+			k.resolveGroup(ctx, g, objs)
+		}
+		joined = nil
+	}
+	return nil
+}
+
+// resolveGroup resolves the metrics of g against objs.
+func (k *kMetrics) resolveGroup(ctx context.Context, g *snapshotGroup, objs []*object) {
+	for _, name := range g.metrics {
+		k.resolve(ctx, name, objs)
+	}
+}
+
+// groupResult holds the results of the metrics of a group as the pages
+// of its objects are resolved.
+type groupResult struct {
+	group   *snapshotGroup
+	results []Result
+	errs    []error
+}
+
+func newGroupResult(g *snapshotGroup) *groupResult {
+	r := &groupResult{group: g, results: make([]Result, len(g.metrics)), errs: make([]error, len(g.metrics))}
+	for i := range r.results {
+		r.results[i] = Result{Vals: []interface{}{}, LabelValues: [][]string{}}
+	}
+	return r
+}
+
+// resolvePage resolves the metrics of the group of r against page, one
+// page of its objects, and adds the values to their results. A metric
+// that fails to resolve is not resolved against the following pages.
+func (k *kMetrics) resolvePage(ctx context.Context, r *groupResult, page []*object) {
+	for i, name := range r.group.metrics {
+		if r.errs[i] != nil {
+			continue
+		}
+		res, err := k.evaluate(ctx, name, page)
+		r.results[i].Vals = append(r.results[i].Vals, res.Vals...)
+		r.results[i].LabelValues = append(r.results[i].LabelValues, res.LabelValues...)
+		r.errs[i] = err
+	}
+}
+
+// setResults makes the results of r those of the refresh, once every
+// page is resolved.
+func (k *kMetrics) setResults(r *groupResult) {
+	for i, name := range r.group.metrics {
+		m := k.metricsMap[name]
+		m.result, m.err = r.results[i], r.errs[i]
+		m.listFailures = 0
+	}
+}
+
+// list lists the objects of sk, one page of k.listSettings.PageSize objects
+// at a time, and passes every page to fn before asking for the next one.
+// The objects are only held as long as fn holds them. With metadataOnly
+// only the object metadata is listed.
+func (k *kMetrics) list(ctx context.Context, sk snapshotKey, selector labels.Selector, metadataOnly bool, fn func(page []*object)) error {
+	// Kinds registered in the scheme are listed typed, which saves
+	// converting every object to JSON. So are metadata lists.
+	_, typed := typedList(sk.gvk)
+	typed = typed || metadataOnly
+
+	opts := &client.ListOptions{Namespace: sk.namespace, LabelSelector: selector, Limit: k.listSettings.PageSize}
+	if k.listSettings.FromCache {
+		opts.Raw = &metav1.ListOptions{ResourceVersion: "0"}
+	}
+	count := 0
+	start := time.Now()
+	for {
+		var list client.ObjectList
+		if metadataOnly {
+			m := &metav1.PartialObjectMetadataList{}
+			m.SetGroupVersionKind(sk.gvk.GroupVersion().WithKind(sk.gvk.Kind + "List"))
+			list = m
+		} else if typed {
+			list, _ = typedList(sk.gvk)
+		} else {
+			u := &unstructured.UnstructuredList{}
+			u.SetGroupVersionKind(sk.gvk)
+			list = u
+		}
+		err := cl.List(ctx, list, opts)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err != nil {
+			k.telemetry.RecordList(ctx, sk.gvk.String(), count, time.Since(start), err)
+			return err
+		}
+		items, err := meta.ExtractList(list)
+		if err != nil {
+			return err
+		}
+
+		vals := make([]*object, 0, len(items))
+		for _, i := range items {
+			if typed {
+				i.GetObjectKind().SetGroupVersionKind(sk.gvk)
+				vals = append(vals, typedObject(i))
+				continue
+			}
 			// Convert the resource into byte.
-			b, err := i.MarshalJSON()
+			b, err := json.Marshal(i)
 			if err != nil {
 				return err
 			}
@@ -72,65 +460,363 @@ func (k *kMetrics) Update() error {
 			if err != nil {
 				return err
 			}
-			if vals == nil {
-				vals = []*ajson.Node{root}
-			} else {
-				vals = append(vals, root)
-			}
+			vals = append(vals, jsonObject(root))
+		}
+		count += len(vals)
+		fn(vals)
 
+		if list.GetContinue() == "" {
+			break
 		}
-		k.metricsMap[key].Data = vals
+		opts.Continue = list.GetContinue()
+		// The following pages are read at the resource version of
+		// the first one, which the continue token holds.
+		opts.Raw = nil
 	}
+	k.telemetry.RecordList(ctx, sk.gvk.String(), count, time.Since(start), nil)
+
 	return nil
 }
 
+// Values returns the metric as resolved by the last refresh.
 func (k *kMetrics) Values(metric string) (Result, error) {
+	m := k.metricsMap[metric]
+	return m.result, m.err
+}
+
+// resolve resolves the metric against objs. Values that cannot be
+// evaluated are recorded here, once per refresh, rather than on every
+// scrape.
+func (k *kMetrics) resolve(ctx context.Context, metric string, objs []*object) {
+	m := k.metricsMap[metric]
+	m.result, m.err = k.evaluate(ctx, metric, objs)
+	m.listFailures = 0
+}
+
+// evaluate resolves the value and labels of the metric for every object
+// in objs.
+func (k *kMetrics) evaluate(ctx context.Context, metric string, objs []*object) (Result, error) {
 
 	res := Result{Vals: []interface{}{}, LabelValues: [][]string{}}
 
-	// Iterate over the Data associated with the metric value.
-	for _, val := range k.metricsMap[metric].Data {
-		// Resolve the Value.
-		v, err := ajson.Eval(val, k.metricsMap[metric].Path)
-		if err != nil {
-			log.Info(err.Error())
-			continue
+	// Iterate over the objects of the metric.
+	for _, val := range objs {
+		// Resolve the Value. A value that cannot be resolved is kept
+		// as nil so that the metric's onMissing policy applies to it.
+		var result interface{}
+		var err error
+		switch {
+		case k.metricsMap[metric].expr != nil:
+			result, err = k.metricsMap[metric].evalExpr(val)
+			if err != nil {
+				k.logger.Debug("error evaluating expr", zap.String("metric", metric), zap.String("expr", k.metricsMap[metric].Expr), zap.Error(err))
+				k.telemetry.RecordEvaluationError(ctx, metric, "expr")
+				result, err = nil, nil
+			}
+		case k.metricsMap[metric].Info && k.metricsMap[metric].Path == "":
+			result = true
+		default:
+			result, err = k.metricsMap[metric].value(val)
 		}
-		result, err := v.Value()
 		if err != nil {
-			log.Info(err.Error())
-			continue
+			k.logger.Debug("error evaluating value path", zap.String("metric", metric), zap.String("path", k.metricsMap[metric].Path), zap.Error(err))
+			k.telemetry.RecordEvaluationError(ctx, metric, "path")
+			result = nil
+		}
+
+		// A path selecting several nodes, such as the status of every
+		// condition, yields one value per node.
+		results := []interface{}{result}
+		nodes, expanded := result.([]*ajson.Node)
+		if expanded {
+			results, err = nodeValues(nodes)
+			if err != nil {
+				return res, err
+			}
+		}
+		// Every node selected by an info metric counts as 1. Null and
+		// empty nodes, such as unset fields, describe nothing and are
+		// dropped once the labels are resolved.
+		var empty []bool
+		if k.metricsMap[metric].Info {
+			for i, r := range results {
+				if expanded && (r == nil || r == "") {
+					if empty == nil {
+						empty = make([]bool, len(results))
+					}
+					empty[i] = true
+				} else if r != nil {
+					results[i] = true
+				}
+			}
 		}
-		res.Vals = append(res.Vals, result)
 
-		lValues := []string{}
+		lValues := make([][]string, len(results))
 		// Iterate over the label paths of the metric to resolve
-		for _, lPath := range k.metricsMap[metric].LabelPath {
+		for li, lPath := range k.metricsMap[metric].LabelPath {
 			// This helps us in setting constant labels.
-			if lPath[0] != '$' {
-				lValues = append(lValues, lPath)
+			if k.metricsMap[metric].labelPaths[li] == nil {
+				for i := range lValues {
+					lValues[i] = append(lValues[i], lPath)
+				}
 				continue
 			}
 			// Resolve the Value.
-			v, err := ajson.Eval(val, lPath)
+			result, err := k.metricsMap[metric].label(val, li)
 			if err != nil {
 				return res, err
 			}
-			result, err := v.Value()
-			if err != nil {
-				return res, err
+
+			// The nodes of an expanded value are paired with the
+			// nodes of the label path by position. Arrays are
+			// arrayValues, joined into a single label value.
+			lResults, ok := result.([]interface{})
+			if !expanded || !ok {
+				l := labelString(k.metricsMap[metric].labelTransforms[li].Apply(result))
+				for i := range lValues {
+					lValues[i] = append(lValues[i], l)
+				}
+				continue
 			}
+			for i := range lValues {
+				var l interface{}
+				if i < len(lResults) {
+					l = lResults[i]
+				}
+				lValues[i] = append(lValues[i], labelString(k.metricsMap[metric].labelTransforms[li].Apply(l)))
+			}
+		}
 
-			str, ok := result.(string)
-			if !ok {
-				lValues = append(lValues, "")
-			} else {
-				lValues = append(lValues, str)
+		if empty != nil {
+			kept := 0
+			for i := range results {
+				if !empty[i] {
+					results[kept], lValues[kept] = results[i], lValues[i]
+					kept++
+				}
 			}
+			results, lValues = results[:kept], lValues[:kept]
+		}
 
+		if k.metricsMap[metric].Reduce != "" && len(results) > 1 {
+			i := reduceIndex(results, k.metricsMap[metric].Unit, k.metricsMap[metric].Reduce)
+			results = results[i : i+1]
+			lValues = lValues[i : i+1]
+		}
+
+		if len(k.metricsMap[metric].States) == 0 {
+			res.Vals = append(res.Vals, results...)
+			res.LabelValues = append(res.LabelValues, lValues...)
+			continue
+		}
+		for i, result := range results {
+			str, ok := result.(string)
+			for _, state := range k.metricsMap[metric].States {
+				var v interface{}
+				if ok {
+					v = str == state
+				}
+				res.Vals = append(res.Vals, v)
+				res.LabelValues = append(res.LabelValues, append(append([]string{}, lValues[i]...), state))
+			}
 		}
-		res.LabelValues = append(res.LabelValues, lValues)
 	}
 
 	return res, nil
 }
+
+// value resolves the value path of the metric against obj.
+func (m *MetricsInfo) value(obj *object) (interface{}, error) {
+	if obj.typed != nil {
+		m.compile(reflect.TypeOf(obj.typed))
+		if m.valueAccessor != nil {
+			return m.valueAccessor.Get(obj.typed), nil
+		}
+	}
+	node, err := obj.JSON()
+	if err != nil {
+		return nil, err
+	}
+	return m.valuePath.Value(node)
+}
+
+// label resolves the i-th label path of the metric against obj. A path
+// selecting several nodes resolves to a []interface{} holding the value
+// of each, and a path selecting a single array to an arrayValue.
+func (m *MetricsInfo) label(obj *object, i int) (interface{}, error) {
+	if j := m.labelJoins[i]; j >= 0 {
+		related, err := m.joins[j].lookup(obj)
+		if err != nil || related == nil {
+			return nil, err
+		}
+		result, err := m.labelReaders[i].Value(related)
+		if err != nil {
+			return nil, err
+		}
+		if nodes, ok := result.([]*ajson.Node); ok {
+			return nodeValues(nodes)
+		}
+		return result, nil
+	}
+	if obj.typed != nil {
+		m.compile(reflect.TypeOf(obj.typed))
+		if m.labelAccessors[i] != nil {
+			return m.labelAccessors[i].Get(obj.typed), nil
+		}
+	}
+	node, err := obj.JSON()
+	if err != nil {
+		return nil, err
+	}
+	result, err := m.labelPaths[i].Value(node)
+	if err != nil {
+		return nil, err
+	}
+	if nodes, ok := result.([]*ajson.Node); ok {
+		return nodeValues(nodes)
+	}
+	return result, nil
+}
+
+// compileExpr parses the expr of m and the paths of its bindings.
+func (m *MetricsInfo) compileExpr(mc MetricsConfig) error {
+	if mc.Properties.Value != "" {
+		return fmt.Errorf("metric %s: value and expr cannot both be set", mc.Name)
+	}
+	for name := range mc.Properties.Bindings {
+		m.bindingNames = append(m.bindingNames, name)
+	}
+	sort.Strings(m.bindingNames)
+	for _, name := range m.bindingNames {
+		path := mc.Properties.Bindings[name]
+		if !strings.HasPrefix(path, "$") {
+			return fmt.Errorf("metric %s: path %q of binding %s must start with $", mc.Name, path, name)
+		}
+		p, err := compileJSONPath(path)
+		if err != nil {
+			return fmt.Errorf("metric %s: invalid path %q of binding %s: %w", mc.Name, path, name, err)
+		}
+		m.bindingPaths = append(m.bindingPaths, p)
+	}
+	expr, err := parseExpression(mc.Properties.Expr, m.bindingNames)
+	if err != nil {
+		return fmt.Errorf("metric %s: invalid expr %q: %w", mc.Name, mc.Properties.Expr, err)
+	}
+	m.expr = expr
+	m.Expr = mc.Properties.Expr
+	return nil
+}
+
+// evalExpr evaluates the expr of the metric against obj.
+func (m *MetricsInfo) evalExpr(obj *object) (interface{}, error) {
+	vals := make(map[string]interface{}, len(m.bindingNames))
+	return m.expr.Eval(func(name string) (interface{}, error) {
+		if v, ok := vals[name]; ok {
+			return v, nil
+		}
+		i := sort.SearchStrings(m.bindingNames, name)
+		v, err := m.binding(obj, i)
+		if err != nil {
+			return nil, fmt.Errorf("binding %s: %w", name, err)
+		}
+		vals[name] = v
+		return v, nil
+	})
+}
+
+// binding resolves the i-th binding of the metric against obj.
+func (m *MetricsInfo) binding(obj *object, i int) (interface{}, error) {
+	if obj.typed != nil {
+		m.compile(reflect.TypeOf(obj.typed))
+		if m.bindingAccessors[i] != nil {
+			return m.bindingAccessors[i].Get(obj.typed), nil
+		}
+	}
+	node, err := obj.JSON()
+	if err != nil {
+		return nil, err
+	}
+	v, err := m.bindingPaths[i].Value(node)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := v.([]*ajson.Node); ok {
+		return nil, errMultipleValues
+	}
+	return v, nil
+}
+
+// compile compiles the accessors of the metric for objects of type t,
+// unless they already are.
+func (m *MetricsInfo) compile(t reflect.Type) {
+	if t == m.accessorType {
+		return
+	}
+	m.accessorType = t
+	m.valueAccessor = compileAccessor(t, m.Path)
+	m.labelAccessors = make([]*accessor, len(m.LabelPath))
+	for i, p := range m.labelPaths {
+		if p != nil && m.labelJoins[i] < 0 {
+			m.labelAccessors[i] = compileAccessor(t, p.path)
+		}
+	}
+	m.bindingAccessors = make([]*accessor, len(m.bindingPaths))
+	for i, p := range m.bindingPaths {
+		m.bindingAccessors[i] = compileAccessor(t, p.path)
+	}
+}
+
+// reduceIndex returns the index of the smallest (min) or largest (max)
+// of vals once converted to unit. Values that cannot be converted are
+// only picked when no value can.
+func reduceIndex(vals []interface{}, unit string, reduce string) int {
+	best := 0
+	var bestVal float64
+	found := false
+	for i, val := range vals {
+		v, err := ConvertUnit(unit, val)
+		if err != nil {
+			continue
+		}
+		if !found || (reduce == "min" && v < bestVal) || (reduce == "max" && v > bestVal) {
+			best, bestVal, found = i, v, true
+		}
+	}
+	return best
+}
+
+// metadataPath reports whether path only reads the object metadata.
+func metadataPath(path string) bool {
+	return path == "$.metadata" || strings.HasPrefix(path, "$.metadata.") || strings.HasPrefix(path, "$.metadata[")
+}
+
+// nodeValues returns the values of nodes.
+func nodeValues(nodes []*ajson.Node) ([]interface{}, error) {
+	vals := make([]interface{}, 0, len(nodes))
+	for _, n := range nodes {
+		v, err := n.Value()
+		if err != nil {
+			return nil, err
+		}
+		vals = append(vals, v)
+	}
+	return vals, nil
+}
+
+// labelString returns the label value for a resolved label path. Only
+// strings are used as label values, and arrays of strings joined by
+// commas.
+func labelString(v interface{}) string {
+	if vals, ok := labelValues(v); ok {
+		strs := make([]string, len(vals))
+		for i, val := range vals {
+			strs[i] = labelString(val)
+		}
+		return strings.Join(strs, ",")
+	}
+	str, ok := v.(string)
+	if !ok {
+		return ""
+	}
+	return str
+}
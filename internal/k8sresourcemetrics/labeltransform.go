@@ -0,0 +1,244 @@
+package k8sresmetric
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/spyzhov/ajson"
+)
+
+// labelTransform is a label path followed by the functions its value is
+// piped through, e.g. $.status.zone | regex('^(.+)-[a-z]$') | lower.
+// Functions get nil for missing values and pass nil on, but default.
+type labelTransform []labelFunc
+
+// labelFunc transforms a resolved label value.
+type labelFunc func(v interface{}) interface{}
+
+// splitLabelTransform splits a label path from the functions its value
+// is piped through. A pipe inside quotes, brackets or parentheses, or
+// doubled as in a || filter, does not split.
+func splitLabelTransform(path string) (string, []string, error) {
+	var parts []string
+	start, depth := 0, 0
+	var quote rune
+	for i, r := range path {
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+		case r == '\'' || r == '"':
+			quote = r
+		case r == '(' || r == '[':
+			depth++
+		case r == ')' || r == ']':
+			depth--
+		case r == '|' && depth == 0:
+			if strings.HasPrefix(path[i+1:], "|") || strings.HasSuffix(path[:i], "|") {
+				continue
+			}
+			parts = append(parts, strings.TrimSpace(path[start:i]))
+			start = i + 1
+		}
+	}
+	if quote != 0 {
+		return "", nil, errors.New("unterminated string")
+	}
+	parts = append(parts, strings.TrimSpace(path[start:]))
+	for _, p := range parts[1:] {
+		if p == "" {
+			return "", nil, errors.New("empty function")
+		}
+	}
+	return parts[0], parts[1:], nil
+}
+
+// parseLabelTransform parses the functions of a label path, each a name
+// optionally followed by quoted string or number arguments.
+func parseLabelTransform(funcs []string) (labelTransform, error) {
+	var t labelTransform
+	for _, f := range funcs {
+		name, args, err := parseLabelCall(f)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", f, err)
+		}
+		fn, err := newLabelFunc(name, args)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", f, err)
+		}
+		t = append(t, fn)
+	}
+	return t, nil
+}
+
+// parseLabelCall splits a function call into its name and arguments.
+func parseLabelCall(call string) (string, []string, error) {
+	name, rest, hasArgs := strings.Cut(call, "(")
+	name = strings.TrimSpace(name)
+	if !hasArgs {
+		return name, nil, nil
+	}
+	rest = strings.TrimSpace(rest)
+	if !strings.HasSuffix(rest, ")") {
+		return "", nil, errors.New("missing )")
+	}
+	rest = strings.TrimSpace(rest[:len(rest)-1])
+
+	var args []string
+	for rest != "" {
+		var arg string
+		if q := rest[0]; q == '\'' || q == '"' {
+			end := strings.IndexByte(rest[1:], q)
+			if end < 0 {
+				return "", nil, errors.New("unterminated string")
+			}
+			arg, rest = rest[1:end+1], rest[end+2:]
+		} else {
+			end := strings.IndexByte(rest, ',')
+			if end < 0 {
+				end = len(rest)
+			}
+			arg, rest = strings.TrimSpace(rest[:end]), rest[end:]
+			if _, err := strconv.ParseFloat(arg, 64); err != nil {
+				return "", nil, fmt.Errorf("argument %s is not a quoted string or a number", arg)
+			}
+		}
+		args = append(args, arg)
+
+		rest = strings.TrimSpace(rest)
+		if rest == "" {
+			break
+		}
+		if rest[0] != ',' {
+			return "", nil, errors.New("arguments must be separated by commas")
+		}
+		rest = strings.TrimSpace(rest[1:])
+		if rest == "" {
+			return "", nil, errors.New("missing argument")
+		}
+	}
+	return name, args, nil
+}
+
+// newLabelFunc returns the label function name applied to args.
+func newLabelFunc(name string, args []string) (labelFunc, error) {
+	want := map[string]int{"lower": 0, "upper": 0, "hash": 0, "regex": 1, "join": 1, "default": 1, "truncate": 1}
+	n, ok := want[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown function %q, want lower, upper, regex, join, default, truncate or hash", name)
+	}
+	if len(args) != n {
+		return nil, fmt.Errorf("%s takes %d arguments, not %d", name, n, len(args))
+	}
+
+	switch name {
+	case "lower":
+		return stringFunc(strings.ToLower), nil
+	case "upper":
+		return stringFunc(strings.ToUpper), nil
+	case "hash":
+		return stringFunc(func(s string) string {
+			h := fnv.New64a()
+			h.Write([]byte(s))
+			return fmt.Sprintf("%016x", h.Sum64())
+		}), nil
+	case "regex":
+		re, err := regexp.Compile(args[0])
+		if err != nil {
+			return nil, err
+		}
+		// The value becomes the first capture group, or the whole
+		// match without groups, and is missing when not matched.
+		return func(v interface{}) interface{} {
+			if v == nil {
+				return nil
+			}
+			m := re.FindStringSubmatch(labelString(v))
+			switch {
+			case m == nil:
+				return nil
+			case len(m) > 1:
+				return m[1]
+			}
+			return m[0]
+		}, nil
+	case "join":
+		sep := args[0]
+		return func(v interface{}) interface{} {
+			vals, ok := labelValues(v)
+			if !ok {
+				return v
+			}
+			strs := make([]string, len(vals))
+			for i, val := range vals {
+				strs[i] = labelString(val)
+			}
+			return strings.Join(strs, sep)
+		}, nil
+	case "default":
+		def := args[0]
+		return func(v interface{}) interface{} {
+			if v == nil || labelString(v) == "" {
+				return def
+			}
+			return v
+		}, nil
+	}
+
+	// truncate
+	n, err := strconv.Atoi(args[0])
+	if err != nil || n < 0 {
+		return nil, fmt.Errorf("invalid length %s", args[0])
+	}
+	return stringFunc(func(s string) string {
+		if utf8.RuneCountInString(s) <= n {
+			return s
+		}
+		return string([]rune(s)[:n])
+	}), nil
+}
+
+// stringFunc returns a label function applying f to the label string of
+// values that are not missing.
+func stringFunc(f func(string) string) labelFunc {
+	return func(v interface{}) interface{} {
+		if v == nil {
+			return nil
+		}
+		return f(labelString(v))
+	}
+}
+
+// Apply pipes v through the functions of t.
+func (t labelTransform) Apply(v interface{}) interface{} {
+	for _, f := range t {
+		v = f(v)
+	}
+	return v
+}
+
+// arrayValue is the value of a path selecting a single array, such as a
+// list of PVC names. Unlike the values of a path selecting several
+// nodes, which are paired with the nodes of an expanded metric value, it
+// makes a single label value.
+type arrayValue []interface{}
+
+// labelValues returns the values of an array label value.
+func labelValues(v interface{}) ([]interface{}, bool) {
+	switch t := v.(type) {
+	case arrayValue:
+		return t, true
+	case []interface{}:
+		return t, true
+	case []*ajson.Node:
+		vals, err := nodeValues(t)
+		return vals, err == nil
+	}
+	return nil, false
+}
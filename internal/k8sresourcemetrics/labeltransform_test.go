@@ -0,0 +1,134 @@
+package k8sresmetric
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	quarkv1alpha1 "quark.netapp.io/otel-controller/internal/quark/v1alpha1"
+)
+
+func TestSplitLabelTransform(t *testing.T) {
+	for path, want := range map[string][]string{
+		"$.status.zone":                                 {"$.status.zone"},
+		"$.status.zone | lower":                         {"$.status.zone", "lower"},
+		"$.status.zone|regex('^(.+)-[a-z]$')|upper":     {"$.status.zone", "regex('^(.+)-[a-z]$')", "upper"},
+		"$.metadata.labels['a|b'] | default('x|y')":     {"$.metadata.labels['a|b']", "default('x|y')"},
+		"$.items[?(@.a == 1 || @.b == 2)].name | lower": {"$.items[?(@.a == 1 || @.b == 2)].name", "lower"},
+	} {
+		p, funcs, err := splitLabelTransform(path)
+		assert.Nil(t, err, path)
+		assert.Equal(t, want, append([]string{p}, funcs...), path)
+	}
+
+	for _, path := range []string{"$.a | ", "$.a | lower |", "$.a | default('x"} {
+		_, _, err := splitLabelTransform(path)
+		assert.NotNil(t, err, path)
+	}
+}
+
+func TestLabelTransform(t *testing.T) {
+	for _, tc := range []struct {
+		funcs []string
+		v     interface{}
+		want  string
+	}{
+		{[]string{"lower"}, "US-East1-B", "us-east1-b"},
+		{[]string{"upper"}, "premium", "PREMIUM"},
+		{[]string{"regex('^(.+)-[a-z]$')"}, "us-east1-b", "us-east1"},
+		{[]string{"regex('[0-9]+')"}, "us-east1-b", "1"},
+		{[]string{"regex('^eu-')"}, "us-east1-b", ""},
+		{[]string{"regex('^eu-')", "default('unknown')"}, "us-east1-b", "unknown"},
+		{[]string{"default('unknown')"}, nil, "unknown"},
+		{[]string{"default('unknown')"}, "", "unknown"},
+		{[]string{"default('unknown')"}, "set", "set"},
+		{[]string{"lower", "default('unknown')"}, nil, "unknown"},
+		{[]string{"join(' ')"}, []interface{}{"pvc-a", "pvc-b"}, "pvc-a pvc-b"},
+		{[]string{"join('/')"}, parseJSON(t, `["a", "b", "c"]`)[0].MustArray(), "a/b/c"},
+		{[]string{"truncate(8)"}, "5f3c0f7e-3a0a-4b8f-9d0a-1b2c3d4e5f60", "5f3c0f7e"},
+		{[]string{"truncate(8)"}, "short", "short"},
+		{[]string{"truncate(2)"}, "zürich", "zü"},
+		{[]string{"hash"}, "5f3c0f7e-3a0a-4b8f-9d0a-1b2c3d4e5f60", "320abdca09a454d8"},
+		{[]string{"hash", "truncate(4)"}, "5f3c0f7e-3a0a-4b8f-9d0a-1b2c3d4e5f60", "320a"},
+		{[]string{"hash"}, nil, ""},
+		{nil, []interface{}{"a", "b"}, "a,b"},
+	} {
+		tr, err := parseLabelTransform(tc.funcs)
+		if assert.Nil(t, err, tc.funcs) {
+			assert.Equal(t, tc.want, labelString(tr.Apply(tc.v)), tc.funcs)
+		}
+	}
+
+	for f, want := range map[string]string{
+		"camel":              `camel: unknown function "camel", want lower, upper, regex, join, default, truncate or hash`,
+		"lower('x')":         "lower('x'): lower takes 0 arguments, not 1",
+		"default()":          "default(): default takes 1 arguments, not 0",
+		"regex('(')":         "regex('('): error parsing regexp: missing closing ): `(`",
+		"truncate(-1)":       "truncate(-1): invalid length -1",
+		"truncate(abc)":      "truncate(abc): argument abc is not a quoted string or a number",
+		"default('a' 'b')":   "default('a' 'b'): arguments must be separated by commas",
+		"default('a',)":      "default('a',): missing argument",
+		"default('a'":        "default('a': missing )",
+		"join(', ', 'x', 2)": "join(', ', 'x', 2): join takes 1 arguments, not 3",
+	} {
+		_, err := parseLabelTransform([]string{f})
+		assert.EqualError(t, err, want, f)
+	}
+}
+
+func TestLabelTransformMetric(t *testing.T) {
+	vol := &quarkv1alpha1.NetAppVolume{ObjectMeta: metav1.ObjectMeta{Name: "vol"}}
+	vol.Status.Zone = "US-East1-B"
+	vol.Status.VolumeUUID = "5f3c0f7e-3a0a-4b8f-9d0a-1b2c3d4e5f60"
+	vol.Status.DataPvcs = []string{"pvc-a", "pvc-b"}
+
+	m := MetricsConfig{Name: "ntap_volume_info", MetricType: "info"}
+	m.Properties.Object = "NetAppVolume"
+	m.Properties.Labels = map[string]string{
+		"region":        "$.status.zone | lower | regex('^(.+)-[a-z]$')",
+		"uuid":          "$.status.VolumeUUID | truncate(8)",
+		"pvcs":          "$.status.dataPvcs",
+		"pvcs_spaced":   "$.status.dataPvcs | join(' ')",
+		"export_policy": "$.status.exportPolicy | default('none')",
+		"constant":      "a | b",
+	}
+	points := evalMetrics(t, []MetricsConfig{m}, vol)
+	assert.Equal(t, []map[string]interface{}{{
+		"value":         1.0,
+		"region":        "us-east1",
+		"uuid":          "5f3c0f7e",
+		"pvcs":          "pvc-a,pvc-b",
+		"pvcs_spaced":   "pvc-a pvc-b",
+		"export_policy": "none",
+		"constant":      "a | b",
+	}}, points["ntap_volume_info"])
+
+	// An array label is joined on every point of a value path selecting
+	// several nodes, not paired with the nodes by position.
+	vol.Status.Conditions = []quarkv1alpha1.NetAppVolumeCondition{
+		{Type: quarkv1alpha1.NetAppVolumeOnline, Status: v1.ConditionTrue},
+		{Type: quarkv1alpha1.NetAppVolumeResizeError, Status: v1.ConditionFalse},
+	}
+	cond := MetricsConfig{Name: "ntap_volume_condition"}
+	cond.Properties.Object = "NetAppVolume"
+	cond.Properties.Value = "$.status.conditions[*].status"
+	cond.Properties.Labels = map[string]string{
+		"condition": "$.status.conditions[*].type",
+		"pvcs":      "$.status.dataPvcs",
+	}
+	points = evalMetrics(t, []MetricsConfig{cond}, vol)
+	assert.Equal(t, []map[string]interface{}{
+		{"value": 1.0, "condition": string(quarkv1alpha1.NetAppVolumeOnline), "pvcs": "pvc-a,pvc-b"},
+		{"value": 0.0, "condition": string(quarkv1alpha1.NetAppVolumeResizeError), "pvcs": "pvc-a,pvc-b"},
+	}, points["ntap_volume_condition"])
+
+	// Functions do not stop metadata from being listed alone.
+	km := &kMetrics{metricsMap: make(map[string]*MetricsInfo)}
+	m.Properties.Labels = map[string]string{"name": "$.metadata.name | upper"}
+	assert.Nil(t, km.RegisterMetric(m))
+	assert.True(t, km.metricsMap["ntap_volume_info"].metadataOnly)
+
+	m.Properties.Labels = map[string]string{"region": "$.status.zone | lowercase"}
+	assert.EqualError(t, km.RegisterMetric(m), `metric ntap_volume_info: invalid functions of label region: lowercase: unknown function "lowercase", want lower, upper, regex, join, default, truncate or hash`)
+}
@@ -0,0 +1,28 @@
+package k8sresmetric
+
+import "fmt"
+
+// DefaultPageSize is the number of objects asked for per List call when
+// no page size is configured.
+const DefaultPageSize = 500
+
+// ListSettings controls how objects are listed from the API server.
+type ListSettings struct {
+	// PageSize is the number of objects asked for per List call. Large
+	// kinds are listed in several pages, which keeps the responses and
+	// the memory they take bounded. 0 lists all objects in one call.
+	PageSize int64 `mapstructure:"pageSize"`
+	// FromCache serves the lists from the API server watch cache, with
+	// resourceVersion "0", instead of reading them from etcd. Lists may
+	// then be slightly stale, and API servers that do not paginate
+	// their cache ignore the page size.
+	FromCache bool `mapstructure:"fromCache"`
+}
+
+// Validate checks the page size.
+func (s *ListSettings) Validate() error {
+	if s.PageSize < 0 {
+		return fmt.Errorf("list page size %d is negative", s.PageSize)
+	}
+	return nil
+}
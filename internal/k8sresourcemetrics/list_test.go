@@ -0,0 +1,177 @@
+package k8sresmetric
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"runtime"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	quarkv1alpha1 "quark.netapp.io/otel-controller/internal/quark/v1alpha1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+)
+
+func TestUpdatePaginated(t *testing.T) {
+	var objs []client.Object
+	for i := 0; i < 5; i++ {
+		objs = append(objs, &quarkv1alpha1.NetAppVolumeReplication{
+			ObjectMeta: metav1.ObjectMeta{Name: fmt.Sprintf("rep-%d", i), Namespace: "ns"},
+			Status:     quarkv1alpha1.NetAppVolumeReplicationStatus{LastTransferSize: int64(i)},
+		})
+	}
+	defer setTestClient(t, objs...)()
+
+	var calls []metav1.ListOptions
+	paginate(func(opts metav1.ListOptions, list client.ObjectList) {
+		calls = append(calls, opts)
+	})
+
+	tel, _ := newTestTelemetry(t)
+	km := &kMetrics{metricsMap: make(map[string]*MetricsInfo), listSettings: ListSettings{PageSize: 2, FromCache: true}, logger: zap.NewNop(), telemetry: tel}
+	m := MetricsConfig{Name: "size"}
+	m.Properties.Object = "NetAppVolumeReplication"
+	m.Properties.Value = "$.status.lastTransferSize"
+	assert.Nil(t, km.RegisterMetric(m))
+	assert.Nil(t, km.Update(context.Background()))
+
+	r, err := km.Values("size")
+	assert.Nil(t, err)
+	assert.Equal(t, []interface{}{nil, 1.0, 2.0, 3.0, 4.0}, r.Vals)
+
+	if assert.Len(t, calls, 3) {
+		// Only the first page is read from the cache, the others
+		// follow the continue token.
+		assert.Equal(t, "0", calls[0].ResourceVersion)
+		assert.Equal(t, "", calls[0].Continue)
+		assert.Equal(t, "", calls[1].ResourceVersion)
+		assert.Equal(t, "2", calls[1].Continue)
+		assert.Equal(t, "4", calls[2].Continue)
+		for _, c := range calls {
+			assert.Equal(t, int64(2), c.Limit)
+		}
+	}
+}
+
+func TestUpdateReleasesPages(t *testing.T) {
+	var objs []client.Object
+	for i := 0; i < 5; i++ {
+		objs = append(objs, &quarkv1alpha1.NetAppVolumeReplication{
+			ObjectMeta: metav1.ObjectMeta{Name: fmt.Sprintf("rep-%d", i), Namespace: "ns"},
+			Status:     quarkv1alpha1.NetAppVolumeReplicationStatus{LastTransferSize: int64(i)},
+		})
+	}
+	defer setTestClient(t, objs...)()
+
+	// The first page is watched, and checked to be released by the
+	// time the last one is asked for.
+	var released atomic.Bool
+	releasedFirst := false
+	calls := 0
+	paginate(func(opts metav1.ListOptions, list client.ObjectList) {
+		calls++
+		switch calls {
+		case 1:
+			items := list.(*quarkv1alpha1.NetAppVolumeReplicationList).Items
+			runtime.SetFinalizer(&items[0], func(*quarkv1alpha1.NetAppVolumeReplication) { released.Store(true) })
+		case 3:
+			for i := 0; i < 20 && !released.Load(); i++ {
+				runtime.GC()
+				time.Sleep(10 * time.Millisecond)
+			}
+			releasedFirst = released.Load()
+		}
+	})
+
+	tel, _ := newTestTelemetry(t)
+	km := &kMetrics{metricsMap: make(map[string]*MetricsInfo), listSettings: ListSettings{PageSize: 2}, logger: zap.NewNop(), telemetry: tel}
+	m := MetricsConfig{Name: "size"}
+	m.Properties.Object = "NetAppVolumeReplication"
+	m.Properties.Value = "$.status.lastTransferSize"
+	assert.Nil(t, km.RegisterMetric(m))
+	assert.Nil(t, km.Update(context.Background()))
+
+	assert.Equal(t, 3, calls)
+	assert.True(t, releasedFirst)
+	r, err := km.Values("size")
+	assert.Nil(t, err)
+	assert.Equal(t, []interface{}{nil, 1.0, 2.0, 3.0, 4.0}, r.Vals)
+}
+
+// paginate makes cl answer in pages. The fake client does not paginate,
+// so pages are cut from its answer with the continue token holding the
+// offset. Every page is passed to onList with the options asking for it.
+func paginate(onList func(opts metav1.ListOptions, list client.ObjectList)) {
+	cl = interceptor.NewClient(cl.(client.WithWatch), interceptor.Funcs{
+		List: func(ctx context.Context, c client.WithWatch, list client.ObjectList, opts ...client.ListOption) error {
+			lo := &client.ListOptions{}
+			lo.ApplyOptions(opts)
+			if err := c.List(ctx, list, opts...); err != nil {
+				return err
+			}
+			items, err := meta.ExtractList(list)
+			if err != nil {
+				return err
+			}
+			offset, _ := strconv.Atoi(lo.Continue)
+			end := offset + int(lo.Limit)
+			list.SetContinue(strconv.Itoa(end))
+			if end >= len(items) {
+				end = len(items)
+				list.SetContinue("")
+			}
+			if err := meta.SetList(list, items[offset:end]); err != nil {
+				return err
+			}
+			onList(*lo.AsListOptions(), list)
+			return nil
+		},
+	})
+}
+
+func TestUpdateMetadataOnly(t *testing.T) {
+	defer setTestClient(t, &quarkv1alpha1.NetAppVolumeReplication{
+		ObjectMeta: metav1.ObjectMeta{Name: "rep", Namespace: "ns", Labels: map[string]string{"tier": "gold"}},
+		Status:     quarkv1alpha1.NetAppVolumeReplicationStatus{LastTransferSize: 4096},
+	})()
+
+	tel, _ := newTestTelemetry(t)
+	km := &kMetrics{metricsMap: make(map[string]*MetricsInfo), logger: zap.NewNop(), telemetry: tel}
+	info := MetricsConfig{Name: "info", MetricType: "info"}
+	info.Properties.Object = "NetAppVolumeReplication"
+	info.Properties.Labels = map[string]string{"name": "$.metadata.name", "tier": "$.metadata.labels.tier", "team": "storage"}
+	assert.Nil(t, km.RegisterMetric(info))
+
+	// Only metadata is listed when every metric of the kind only reads
+	// metadata.
+	assert.Nil(t, km.Update(context.Background()))
+	// The accessors are compiled for the type of the listed objects.
+	assert.Equal(t, reflect.TypeOf(&metav1.PartialObjectMetadata{}), km.metricsMap["info"].accessorType)
+	r, err := km.Values("info")
+	assert.Nil(t, err)
+	assert.Equal(t, []interface{}{true}, r.Vals)
+	labels := make(map[string]string)
+	for i, l := range km.LabelNames("info") {
+		labels[l] = r.LabelValues[0][i]
+	}
+	assert.Equal(t, map[string]string{"name": "rep", "tier": "gold", "team": "storage"}, labels)
+
+	// A metric of the same kind reading the status needs the whole
+	// objects, which the metadata metric then shares.
+	size := MetricsConfig{Name: "size"}
+	size.Properties.Object = "NetAppVolumeReplication"
+	size.Properties.Value = "$.status.lastTransferSize"
+	assert.Nil(t, km.RegisterMetric(size))
+	assert.Nil(t, km.Update(context.Background()))
+	assert.Equal(t, reflect.TypeOf(&quarkv1alpha1.NetAppVolumeReplication{}), km.metricsMap["info"].accessorType)
+	r, err = km.Values("size")
+	assert.Nil(t, err)
+	assert.Equal(t, []interface{}{4096.0}, r.Vals)
+}
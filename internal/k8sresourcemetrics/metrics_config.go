@@ -1,11 +1,16 @@
 package k8sresmetric
 
 import (
+	"errors"
 	"fmt"
+	"math"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
+	"time"
 
-	log "github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/api/meta"
 	"k8s.io/apimachinery/pkg/runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 
@@ -14,6 +19,7 @@ import (
 	"k8s.io/client-go/discovery"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
 	"k8s.io/client-go/rest"
+	"k8s.io/client-go/restmapper"
 	quarkv1alpha1 "quark.netapp.io/otel-controller/internal/quark/v1alpha1"
 )
 
@@ -22,25 +28,119 @@ func init() {
 	utilruntime.Must(quarkv1alpha1.AddToScheme(rscheme))
 }
 
-var resourceMap map[string]schema.GroupVersionKind
-var shortNamesMap map[string]schema.GroupVersionKind
 var rscheme = runtime.NewScheme()
 
+// Types of the metrics.
+const (
+	MetricTypeGauge   = "gauge"
+	MetricTypeCounter = "counter"
+	MetricTypeInfo    = "info"
+	// MetricTypeCount counts objects, aggregated by all their labels.
+	MetricTypeCount                = "count"
+	MetricTypeHistogram            = "histogram"
+	MetricTypeExponentialHistogram = "exponential_histogram"
+)
+
 type MetricsConfig struct {
 	// All fields below must be exported (start with a capital letter)
 	// so that the yaml.UnmarshalStrict() method can set them.
-	Name       string `yaml:"name"`
-	Help       string `yaml:"help"`
+	Name string `yaml:"name"`
+	Help string `yaml:"help"`
+	// MetricType is gauge, counter, info, count, histogram or
+	// exponential_histogram. Info metrics emit 1 for every node selected
+	// by value, or once per object when value is empty, and carry their
+	// information in labels. Count metrics emit the number of objects
+	// per value of their GroupBy labels. Histogram metrics bucket the
+	// values of the objects as set by Histogram.
 	MetricType string `yaml:"type"`
 	Properties struct {
-		PropertyType string            `yaml:"type"`
-		Object       string            `yaml:"object"`
-		Value        string            `yaml:"value"`
-		Unit         string            `yaml:"unit"`
-		Labels       map[string]string `yaml:"labels"`
+		PropertyType string `yaml:"type"`
+		// Object names the kind of the objects as Kind, Kind.group,
+		// group/version/Kind or a plural, singular or short resource
+		// name. Names served by several groups must be qualified with
+		// their group, or as /version/Kind for the core group.
+		Object string `yaml:"object"`
+		Value  string `yaml:"value,omitempty"`
+		Unit   string `yaml:"unit,omitempty"`
+		// Labels maps label names to constant values or paths. Paths
+		// of the form <join>:$... read the related object of a join.
+		// Paths may be followed by functions their value is piped
+		// through: lower, upper, regex('<re>'), join('<sep>'),
+		// default('<value>'), truncate(<n>) and hash, e.g.
+		// $.status.zone | regex('^(.+)-[a-z]$') | lower.
+		Labels map[string]string `yaml:"labels,omitempty"`
+		// Join names the joins of the metric, each looking up an object
+		// related to every object.
+		Join map[string]JoinConfig `yaml:"join,omitempty"`
+		// GroupBy maps the label names of a count metric to the paths
+		// grouping the objects counted.
+		GroupBy map[string]string `yaml:"groupBy,omitempty"`
+		// Expr computes the value from the values selected by
+		// Bindings, e.g. "size > 0 ? used / size : null" with the
+		// bindings used and size. It replaces Value.
+		Expr     string            `yaml:"expr,omitempty"`
+		Bindings map[string]string `yaml:"bindings,omitempty"`
+		// Selector restricts the objects to those matching a label
+		// selector, e.g. app=db,tier!=cache.
+		Selector string `yaml:"selector,omitempty"`
+		// States turns the metric into a state set: every object gets
+		// one series per state, labelled state, that is 1 for the
+		// state matching the value and 0 otherwise.
+		States []string `yaml:"states,omitempty"`
+		// Reduce keeps, per object, only the smallest (min) or the
+		// largest (max) of the values selected by value, compared
+		// after unit conversion.
+		Reduce string `yaml:"reduce,omitempty"`
+		// OnMissing decides what is emitted when the value is missing
+		// or cannot be parsed: skip, zero, nan or default:<v>.
+		// Defaults to skip.
+		OnMissing string `yaml:"onMissing,omitempty"`
+		// Aggregate emits one data point per group of objects sharing
+		// the values of some labels instead of one per object.
+		Aggregate *Aggregation `yaml:"aggregate,omitempty"`
+		// Histogram sets the buckets of histogram metrics and the
+		// labels grouping their objects.
+		Histogram *HistogramSettings `yaml:"histogram,omitempty"`
 	} `yaml:"properties"`
 }
 
+// Actions of an onMissing policy.
+const (
+	OnMissingSkip    = "skip"
+	OnMissingZero    = "zero"
+	OnMissingNaN     = "nan"
+	OnMissingDefault = "default"
+)
+
+// MissingPolicy is the parsed form of MetricsConfig.Properties.OnMissing.
+type MissingPolicy struct {
+	Action string
+	// Value is only used by the default action.
+	Value float64
+}
+
+// ParseMissingPolicy parses an onMissing setting.
+func ParseMissingPolicy(s string) (MissingPolicy, error) {
+	switch s {
+	case "", OnMissingSkip:
+		return MissingPolicy{Action: OnMissingSkip}, nil
+	case OnMissingZero:
+		return MissingPolicy{Action: OnMissingZero}, nil
+	case OnMissingNaN:
+		return MissingPolicy{Action: OnMissingNaN, Value: math.NaN()}, nil
+	}
+
+	def, ok := strings.CutPrefix(s, OnMissingDefault+":")
+	if !ok {
+		return MissingPolicy{}, fmt.Errorf("invalid onMissing %q, want skip, zero, nan or default:<value>", s)
+	}
+	v, err := strconv.ParseFloat(strings.TrimSpace(def), 64)
+	if err != nil {
+		return MissingPolicy{}, fmt.Errorf("invalid onMissing default %q: %w", def, err)
+	}
+	return MissingPolicy{Action: OnMissingDefault, Value: v}, nil
+}
+
 type ExporterConfig struct {
 	Metrics []MetricsConfig `yaml:"metrics"`
 }
@@ -80,68 +180,175 @@ type ResourceMetricsCollector struct {
 }
 
 var (
-	dClient *discovery.DiscoveryClient
+	dClient discovery.DiscoveryInterface
 	cl      client.Client
 )
 
-// This function sets the map containing resource name and its corresponding
-// gvk.
+var (
+	// gvkMu guards restMapper, objectNames and lastDiscovery, which
+	// discovery refreshes replace.
+	gvkMu sync.RWMutex
+	// restMapper maps the kinds served by the cluster to their
+	// versions, preferred version first.
+	restMapper meta.RESTMapper
+	// objectNames holds the kinds matching every kind, plural,
+	// singular and short name served by the cluster.
+	objectNames   map[string][]schema.GroupKind
+	lastDiscovery time.Time
+	// discoveryInterval is how often the kinds served by the cluster
+	// are discovered again, to pick up CRDs installed since.
+	discoveryInterval = 5 * time.Minute
+	// discoveryMissInterval is how often a kind that cannot be
+	// resolved triggers a discovery.
+	discoveryMissInterval = 30 * time.Second
+)
+
+// errObjectNotFound is returned for object references that no kind
+// served by the cluster matches.
+var errObjectNotFound = errors.New("no kind found")
+
+// This function discovers the kinds served by the cluster and sets the
+// mapper and names used to resolve object references.
 func setGVKMap() error {
-	// Initialize the Map
-	resourceMap = make(map[string]schema.GroupVersionKind)
-	shortNamesMap = make(map[string]schema.GroupVersionKind)
+	// Groups that fail to be discovered, such as an unavailable
+	// aggregated API, leave out their resources only.
+	groupResources, err := restmapper.GetAPIGroupResources(dClient)
+	if err != nil && !(discovery.IsGroupDiscoveryFailedError(err) && len(groupResources) > 0) {
+		return fmt.Errorf("failed to list resources on server: %w", err)
+	}
 
-	// List resources on the server
-	_, resourceList, err := discovery.ServerGroupsAndResources(dClient)
-	if err != nil {
-		log.Errorf("Failed to list resources on server: %v", err)
-		return err
-	}
-	var version string
-	// Iterate over resource list to set the Map
-	for _, resource := range resourceList {
-
-		gv, _ := schema.ParseGroupVersion(resource.GroupVersion)
-		// The apiversion seems to be like v1 or astra.netapp.io/v1aplha1
-		// We just need v1 or v1aplha1
-		vals := strings.Split(resource.GroupVersion, "/")
-		if len(vals) > 1 {
-			version = vals[1]
-		} else {
-			version = vals[0]
+	names := make(map[string][]schema.GroupKind)
+	add := func(name string, gk schema.GroupKind) {
+		if name == "" {
+			return
 		}
-		// Iterate over resource
-		for _, apiRes := range resource.APIResources {
-			// See if the resource kind is present
-			_, ok := resourceMap[apiRes.Kind]
-			if !ok {
-				// Set map.
-				resourceMap[apiRes.Kind] = schema.GroupVersionKind{
-					Group:   gv.Group,
-					Kind:    apiRes.Kind,
-					Version: version,
-				}
+		for _, known := range names[name] {
+			if known == gk {
+				return
 			}
-			if len(apiRes.ShortNames) > 0 {
-				shortNamesMap[apiRes.ShortNames[0]] = resourceMap[apiRes.Kind]
+		}
+		names[name] = append(names[name], gk)
+	}
+	for _, group := range groupResources {
+		for _, resources := range group.VersionedResources {
+			for _, r := range resources {
+				// Skip subresources such as pods/status.
+				if strings.Contains(r.Name, "/") {
+					continue
+				}
+				gk := schema.GroupKind{Group: group.Group.Name, Kind: r.Kind}
+				add(r.Kind, gk)
+				add(r.Name, gk)
+				singular := r.SingularName
+				if singular == "" {
+					singular = strings.ToLower(r.Kind)
+				}
+				add(singular, gk)
+				for _, short := range r.ShortNames {
+					add(short, gk)
+				}
 			}
-
 		}
 	}
+
+	gvkMu.Lock()
+	restMapper = restmapper.NewDiscoveryRESTMapper(groupResources)
+	objectNames = names
+	gvkMu.Unlock()
 	return nil
 }
 
-func getGVK(resource string) (schema.GroupVersionKind, error) {
+// refreshGVKMap discovers the kinds served by the cluster again unless
+// the last discovery is more recent than maxAge.
+func refreshGVKMap(maxAge time.Duration) error {
+	gvkMu.Lock()
+	if dClient == nil || time.Since(lastDiscovery) < maxAge {
+		gvkMu.Unlock()
+		return nil
+	}
+	// Failed discoveries count too, so that a failing API server is not
+	// asked on every lookup.
+	lastDiscovery = time.Now()
+	gvkMu.Unlock()
 
-	v, ok := resourceMap[resource]
-	if !ok {
-		v, ok = shortNamesMap[resource]
-		if !ok {
-			return schema.GroupVersionKind{}, fmt.Errorf("GVK not found for resource %s", resource)
+	return setGVKMap()
+}
+
+// resolveObject resolves an object reference to the kind it names, at
+// the version preferred by the API server unless the reference pins one.
+// A reference is either group/version/Kind, with an empty group for the
+// core group, or a kind, plural, singular or short name optionally
+// qualified by its group, e.g. Event.events.k8s.io or nv.quark.netapp.io.
+// Unqualified names served by several groups are ambiguous, even when
+// the core group is one of them.
+func resolveObject(ref string) (schema.GroupVersionKind, error) {
+	gvkMu.RLock()
+	defer gvkMu.RUnlock()
+
+	if restMapper == nil {
+		return schema.GroupVersionKind{}, fmt.Errorf("%w: kinds not discovered", errObjectNotFound)
+	}
+
+	if strings.Contains(ref, "/") {
+		parts := strings.Split(ref, "/")
+		if len(parts) != 3 || parts[1] == "" || parts[2] == "" {
+			return schema.GroupVersionKind{}, fmt.Errorf("invalid object reference %q, want group/version/Kind", ref)
+		}
+		m, err := restMapper.RESTMapping(schema.GroupKind{Group: parts[0], Kind: parts[2]}, parts[1])
+		if meta.IsNoMatchError(err) {
+			return schema.GroupVersionKind{}, fmt.Errorf("%w: %w", errObjectNotFound, err)
 		}
-		return v, nil
+		if err != nil {
+			return schema.GroupVersionKind{}, err
+		}
+		return m.GroupVersionKind, nil
+	}
+
+	name, group, qualified := strings.Cut(ref, ".")
+	var candidates []schema.GroupKind
+	for _, gk := range objectNames[name] {
+		if !qualified || gk.Group == group {
+			candidates = append(candidates, gk)
+		}
+	}
+	switch len(candidates) {
+	case 0:
+		return schema.GroupVersionKind{}, errObjectNotFound
+	case 1:
+	default:
+		var options []string
+		for _, gk := range candidates {
+			// Kinds of the core group are qualified as /version/Kind.
+			if m, err := restMapper.RESTMapping(gk); err == nil && gk.Group == "" {
+				options = append(options, "/"+m.GroupVersionKind.Version+"/"+gk.Kind)
+				continue
+			}
+			options = append(options, gk.Kind+"."+gk.Group)
+		}
+		sort.Strings(options)
+		return schema.GroupVersionKind{}, fmt.Errorf("object %q is ambiguous, qualify it with its group: %s", ref, strings.Join(options, ", "))
+	}
+
+	m, err := restMapper.RESTMapping(candidates[0])
+	if err != nil {
+		return schema.GroupVersionKind{}, err
+	}
+	return m.GroupVersionKind, nil
+}
+
+func getGVK(resource string) (schema.GroupVersionKind, error) {
+	v, err := resolveObject(resource)
+	if errors.Is(err, errObjectNotFound) {
+		// The kind may have been installed after the last discovery.
+		if rErr := refreshGVKMap(discoveryMissInterval); rErr != nil {
+			return schema.GroupVersionKind{}, fmt.Errorf("GVK not found for resource %s: %w", resource, rErr)
+		}
+		v, err = resolveObject(resource)
+	}
+	if errors.Is(err, errObjectNotFound) {
+		return schema.GroupVersionKind{}, fmt.Errorf("GVK not found for resource %s", resource)
 	}
-	return v, nil
+	return v, err
 }
 
 // Set neccesary k8s client.
@@ -149,14 +356,15 @@ func SetClients(rCfg *rest.Config) (err error) {
 
 	dClient, err = discovery.NewDiscoveryClientForConfig(rCfg)
 	if err != nil {
-		log.Errorf("Error building discovery client: %v", err.Error())
-		return err
+		return fmt.Errorf("error building discovery client: %w", err)
 	}
 	cl, err = client.New(rCfg, client.Options{Scheme: rscheme})
 	if err != nil {
-		log.Error("Failed to create k8s client: ", err)
-		return err
+		return fmt.Errorf("failed to create k8s client: %w", err)
 	}
 
+	gvkMu.Lock()
+	lastDiscovery = time.Now()
+	gvkMu.Unlock()
 	return setGVKMap()
 }
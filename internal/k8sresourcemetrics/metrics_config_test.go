@@ -1,10 +1,27 @@
 package k8sresmetric
 
 import (
+	"context"
+	"errors"
+	"math"
 	"testing"
 
 	"github.com/spyzhov/ajson"
 	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/attribute"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	quarkv1alpha1 "quark.netapp.io/otel-controller/internal/quark/v1alpha1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
 )
 
 var jsonStr1 string = `
@@ -143,7 +160,24 @@ metrics:
 #       foo: bar
 `
 
+func newTestTelemetry(t *testing.T) (*Telemetry, *sdkmetric.ManualReader) {
+	reader := sdkmetric.NewManualReader()
+	tel, err := NewTelemetry(sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader)).Meter("test"))
+	assert.Nil(t, err)
+	return tel, reader
+}
+
+// jsonObjects wraps parsed JSON nodes as listed objects.
+func jsonObjects(nodes ...*ajson.Node) []*object {
+	objs := make([]*object, 0, len(nodes))
+	for _, n := range nodes {
+		objs = append(objs, jsonObject(n))
+	}
+	return objs
+}
+
 func TestKMetricsValue(t *testing.T) {
+	tel, _ := newTestTelemetry(t)
 	var rNodes []*ajson.Node
 	rNode1, err := ajson.Unmarshal([]byte(jsonStr1))
 	assert.Nil(t, err)
@@ -152,17 +186,16 @@ func TestKMetricsValue(t *testing.T) {
 
 	rNodes = append(rNodes, rNode1, rNode2)
 
-	mInf := &MetricsInfo{
-		Data:      rNodes,
-		Path:      "$.value",
-		LabelPath: []string{"$.label"},
-		LabelKeys: []string{"foo"},
-	}
-	nMap := make(map[string]*MetricsInfo)
-	nMap["metric"] = mInf
 	km := &kMetrics{
-		metricsMap: nMap,
+		metricsMap: make(map[string]*MetricsInfo),
+		logger:     zap.NewNop(),
+		telemetry:  tel,
 	}
+	m := MetricsConfig{Name: "metric"}
+	m.Properties.Value = "$.value"
+	m.Properties.Labels = map[string]string{"foo": "$.label"}
+	assert.Nil(t, km.RegisterMetric(m))
+	km.resolve(context.Background(), "metric", jsonObjects(rNodes...))
 
 	r, err := km.Values("metric")
 	assert.Equal(t, 1, len(km.LabelNames("metric")))
@@ -171,7 +204,145 @@ func TestKMetricsValue(t *testing.T) {
 }
 
 func TestSet(t *testing.T) {
-	err := SetCollectors(resConfig)
+	tel, _ := newTestTelemetry(t)
+	c, err := SetCollectors(resConfig, nil, ListSettings{}, zap.NewNop(), tel)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(c))
+	assert.Equal(t, 9, len(c[0].MetricConfigList))
+
+}
+
+func TestKMetricsValueLogsFields(t *testing.T) {
+	rNode, err := ajson.Unmarshal([]byte(jsonStr1))
+	assert.Nil(t, err)
+
+	core, logs := observer.New(zapcore.DebugLevel)
+	tel, reader := newTestTelemetry(t)
+	km := &kMetrics{
+		metricsMap: make(map[string]*MetricsInfo),
+		logger:     zap.New(core),
+		telemetry:  tel,
+	}
+	// The path is valid but fails on the object.
+	mc := MetricsConfig{Name: "metric"}
+	mc.Properties.Value = "$[?(@ / 0 == 1)]"
+	assert.Nil(t, km.RegisterMetric(mc))
+	km.resolve(context.Background(), "metric", jsonObjects(rNode))
+
+	r, err := km.Values("metric")
+	assert.Nil(t, err)
+	assert.Equal(t, []interface{}{nil}, r.Vals)
+	// Scrapes between refreshes do not record the error again.
+	_, err = km.Values("metric")
+	assert.Nil(t, err)
+	assert.Equal(t, 1, logs.Len())
+	fields := logs.All()[0].ContextMap()
+	assert.Equal(t, "metric", fields["metric"])
+	assert.Equal(t, "$[?(@ / 0 == 1)]", fields["path"])
+
+	rm := metricdata.ResourceMetrics{}
+	assert.Nil(t, reader.Collect(context.Background(), &rm))
+	assert.Equal(t, 1, len(rm.ScopeMetrics))
+	m := rm.ScopeMetrics[0].Metrics[0]
+	assert.Equal(t, "k8sresmetrics_evaluation_errors", m.Name)
+	dp := m.Data.(metricdata.Sum[int64]).DataPoints[0]
+	assert.Equal(t, int64(1), dp.Value)
+	name, _ := dp.Attributes.Value(attribute.Key("metric"))
+	assert.Equal(t, "metric", name.AsString())
+}
+
+func TestParseMissingPolicy(t *testing.T) {
+	p, err := ParseMissingPolicy("")
+	assert.Nil(t, err)
+	assert.Equal(t, OnMissingSkip, p.Action)
+
+	p, err = ParseMissingPolicy("zero")
+	assert.Nil(t, err)
+	assert.Equal(t, OnMissingZero, p.Action)
+
+	p, err = ParseMissingPolicy("nan")
+	assert.Nil(t, err)
+	assert.True(t, math.IsNaN(p.Value))
+
+	p, err = ParseMissingPolicy("default:-1")
+	assert.Nil(t, err)
+	assert.Equal(t, MissingPolicy{Action: OnMissingDefault, Value: -1}, p)
+
+	_, err = ParseMissingPolicy("default:abc")
+	assert.NotNil(t, err)
+	_, err = ParseMissingPolicy("drop")
+	assert.NotNil(t, err)
+}
+
+func TestUpdateRemovedKind(t *testing.T) {
+	defer setTestClient(t, &quarkv1alpha1.NetAppVolumeReplication{
+		ObjectMeta: metav1.ObjectMeta{Name: "rep", Namespace: "ns"},
+		Status:     quarkv1alpha1.NetAppVolumeReplicationStatus{LastTransferSize: 4096},
+	})()
+
+	tel, _ := newTestTelemetry(t)
+	km := &kMetrics{metricsMap: make(map[string]*MetricsInfo), logger: zap.NewNop(), telemetry: tel}
+	m := MetricsConfig{Name: "size"}
+	m.Properties.Object = "NetAppVolumeReplication"
+	m.Properties.Value = "$.status.lastTransferSize"
+	assert.Nil(t, km.RegisterMetric(m))
+	assert.Nil(t, km.Update(context.Background()))
+	r, err := km.Values("size")
 	assert.Nil(t, err)
+	assert.Equal(t, []interface{}{4096.0}, r.Vals)
+
+	// The objects are kept for a few failed lists.
+	listErr := errors.New("unavailable")
+	cl = interceptor.NewClient(cl.(client.WithWatch), interceptor.Funcs{
+		List: func(ctx context.Context, c client.WithWatch, list client.ObjectList, opts ...client.ListOption) error {
+			if listErr != nil {
+				return listErr
+			}
+			return c.List(ctx, list, opts...)
+		},
+	})
+	for i := 1; i < maxListFailures; i++ {
+		assert.Nil(t, km.Update(context.Background()))
+		r, _ = km.Values("size")
+		assert.Len(t, r.Vals, 1)
+	}
+	assert.Nil(t, km.Update(context.Background()))
+	r, _ = km.Values("size")
+	assert.Empty(t, r.Vals)
 
+	// A removed kind drops the result at once.
+	listErr = nil
+	assert.Nil(t, km.Update(context.Background()))
+	r, _ = km.Values("size")
+	assert.Len(t, r.Vals, 1)
+	objectNames = map[string][]schema.GroupKind{}
+	assert.Nil(t, km.Update(context.Background()))
+	r, _ = km.Values("size")
+	assert.Empty(t, r.Vals)
+}
+
+// setTestClient points the package client at a fake client holding objs
+// and maps their kinds, and returns a function restoring the previous
+// client.
+func setTestClient(t *testing.T, objs ...client.Object) func() {
+	prevCl, prevMapper, prevNames := cl, restMapper, objectNames
+	cl = fake.NewClientBuilder().WithScheme(rscheme).WithObjects(objs...).Build()
+	var gvks []schema.GroupVersionKind
+	var gvs []schema.GroupVersion
+	for _, o := range objs {
+		gvk, err := apiutil.GVKForObject(o, rscheme)
+		assert.Nil(t, err)
+		gvks = append(gvks, gvk)
+		gvs = append(gvs, gvk.GroupVersion())
+	}
+	mapper := meta.NewDefaultRESTMapper(gvs)
+	objectNames = make(map[string][]schema.GroupKind)
+	for _, gvk := range gvks {
+		mapper.Add(gvk, meta.RESTScopeNamespace)
+		objectNames[gvk.Kind] = []schema.GroupKind{gvk.GroupKind()}
+	}
+	restMapper = mapper
+	return func() {
+		cl, restMapper, objectNames = prevCl, prevMapper, prevNames
+	}
 }
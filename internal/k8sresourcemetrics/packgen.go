@@ -0,0 +1,227 @@
+package k8sresmetric
+
+import (
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strings"
+	"unicode"
+
+	"gopkg.in/yaml.v3"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+var (
+	quantityType = reflect.TypeOf(resource.Quantity{})
+	timeType     = reflect.TypeOf(metav1.Time{})
+	typeMetaType = reflect.TypeOf(metav1.TypeMeta{})
+	objMetaType  = reflect.TypeOf(metav1.ObjectMeta{})
+	listMetaType = reflect.TypeOf(metav1.ListMeta{})
+)
+
+// KnownKinds returns the object kinds registered in the scheme for gv,
+// leaving out lists and option types that have no object metadata.
+func KnownKinds(gv schema.GroupVersion) []string {
+	var kinds []string
+	for kind, t := range rscheme.KnownTypes(gv) {
+		if f, ok := t.FieldByName("ObjectMeta"); !ok || f.Type != objMetaType {
+			continue
+		}
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+	return kinds
+}
+
+// GeneratePack proposes a pack for gvk, one of the types registered in
+// the scheme, by walking its Go struct. Numeric, boolean and quantity
+// fields become gauges, times become ages, slices become counts, lists of
+// conditions become condition metrics and string enums become labels of
+// an info metric. Metric names start with prefix.
+func GeneratePack(gvk schema.GroupVersionKind, prefix string) (*Pack, error) {
+	obj, err := rscheme.New(gvk)
+	if err != nil {
+		return nil, err
+	}
+
+	g := &packGenerator{
+		kind:   gvk.Kind,
+		prefix: prefix + strings.ToLower(gvk.Kind) + "_",
+		labels: map[string]string{
+			"name":      "$.metadata.name",
+			"namespace": "$.metadata.namespace",
+		},
+		enums: make(map[string]string),
+	}
+	g.walk(reflect.TypeOf(obj).Elem(), "$", nil)
+
+	if len(g.enums) > 0 {
+		m := g.metric("info", strings.ToLower(gvk.Kind)+" attributes, always 1", MetricTypeInfo)
+		for k, v := range g.enums {
+			m.Properties.Labels[k] = v
+		}
+		g.metrics = append(g.metrics, m)
+	}
+
+	return &Pack{Name: strings.ToLower(gvk.Kind), Version: "v1", Metrics: g.metrics}, nil
+}
+
+// WritePack writes p as a pack YAML file. Generated packs are candidates:
+// review, rename and trim them before shipping.
+func WritePack(w io.Writer, p *Pack) error {
+	if _, err := fmt.Fprintf(w, "# Generated from the Go types of %s, review before use.\n", p.Name); err != nil {
+		return err
+	}
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+	if err := enc.Encode(p); err != nil {
+		return err
+	}
+	return enc.Close()
+}
+
+type packGenerator struct {
+	kind    string
+	prefix  string
+	labels  map[string]string
+	enums   map[string]string
+	metrics []MetricsConfig
+}
+
+// metric returns a metric named after suffix with the common labels.
+func (g *packGenerator) metric(suffix string, help string, metricType string) MetricsConfig {
+	m := MetricsConfig{Name: g.prefix + suffix, Help: help, MetricType: metricType}
+	m.Properties.PropertyType = "kubernetes"
+	m.Properties.Object = g.kind
+	m.Properties.Labels = make(map[string]string, len(g.labels))
+	for k, v := range g.labels {
+		m.Properties.Labels[k] = v
+	}
+	return m
+}
+
+// walk adds candidate metrics for the fields of t, found at path. names
+// holds the Go field names leading to t.
+func (g *packGenerator) walk(t reflect.Type, path string, names []string) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		tag, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if tag == "-" {
+			continue
+		}
+		ft := f.Type
+		if ft.Kind() == reflect.Pointer {
+			ft = ft.Elem()
+		}
+		if ft == typeMetaType || ft == objMetaType || ft == listMetaType {
+			continue
+		}
+		// Inlined structs keep the path of their parent.
+		if tag == "" && f.Anonymous {
+			g.walk(ft, path, names)
+			continue
+		}
+		if tag == "" {
+			tag = f.Name
+		}
+
+		fPath := path + "." + tag
+		fNames := append(append([]string{}, names...), f.Name)
+		suffix := snakeCase(fNames)
+		help := fmt.Sprintf("Value of %s", strings.TrimPrefix(fPath, "$."))
+
+		switch {
+		case ft == quantityType:
+			m := g.metric(suffix, help, MetricTypeGauge)
+			m.Properties.Value = fPath
+			g.metrics = append(g.metrics, m)
+		case ft == timeType:
+			m := g.metric(suffix+"_age_seconds", fmt.Sprintf("Seconds since %s", strings.TrimPrefix(fPath, "$.")), MetricTypeGauge)
+			m.Properties.Value = fPath
+			m.Properties.Unit = "age"
+			g.metrics = append(g.metrics, m)
+		case ft.Kind() == reflect.Struct:
+			g.walk(ft, fPath, fNames)
+		case ft.Kind() == reflect.Slice && ft.Elem().Kind() == reflect.Uint8:
+			// Raw data such as secrets.
+		case ft.Kind() == reflect.Slice && isCondition(ft.Elem()):
+			m := g.metric(suffix, fmt.Sprintf("Status of each entry of %s, 1 when true and 0 when false", strings.TrimPrefix(fPath, "$.")), MetricTypeGauge)
+			m.Properties.Value = fPath + "[*].status"
+			m.Properties.Labels["condition"] = fPath + "[*].type"
+			g.metrics = append(g.metrics, m)
+		case ft.Kind() == reflect.Slice:
+			m := g.metric(suffix, fmt.Sprintf("Number of entries of %s", strings.TrimPrefix(fPath, "$.")), MetricTypeGauge)
+			m.Properties.Value = fPath + ".length"
+			m.Properties.Unit = "count"
+			m.Properties.OnMissing = OnMissingZero
+			g.metrics = append(g.metrics, m)
+		case ft.Kind() == reflect.String:
+			// Only named string types are enums, plain strings are
+			// free text.
+			if ft.PkgPath() != "" && ft.Name() != "string" {
+				g.enums[lowerFirst(snakeToCamel(suffix))] = fPath
+			}
+		case ft.Kind() == reflect.Bool,
+			ft.Kind() >= reflect.Int && ft.Kind() <= reflect.Float64:
+			m := g.metric(suffix, help, MetricTypeGauge)
+			m.Properties.Value = fPath
+			g.metrics = append(g.metrics, m)
+		}
+	}
+}
+
+// isCondition reports whether t looks like a Kubernetes condition.
+func isCondition(t reflect.Type) bool {
+	if t.Kind() != reflect.Struct {
+		return false
+	}
+	_, hasType := t.FieldByName("Type")
+	_, hasStatus := t.FieldByName("Status")
+	return hasType && hasStatus
+}
+
+// snakeCase joins Go field names as a snake case metric name, e.g.
+// Status, LastTransferSize gives status_last_transfer_size.
+func snakeCase(names []string) string {
+	var b strings.Builder
+	for i, name := range names {
+		if i > 0 {
+			b.WriteByte('_')
+		}
+		runes := []rune(name)
+		for j, r := range runes {
+			// Start a new word at a lower to upper case change and
+			// at the last upper case letter of an acronym.
+			if j > 0 && unicode.IsUpper(r) &&
+				(unicode.IsLower(runes[j-1]) || (j+1 < len(runes) && unicode.IsLower(runes[j+1]))) {
+				b.WriteByte('_')
+			}
+			b.WriteRune(unicode.ToLower(r))
+		}
+	}
+	return b.String()
+}
+
+// snakeToCamel turns status_mirror_state into statusMirrorState.
+func snakeToCamel(s string) string {
+	parts := strings.Split(s, "_")
+	for i := 1; i < len(parts); i++ {
+		if parts[i] != "" {
+			parts[i] = strings.ToUpper(parts[i][:1]) + parts[i][1:]
+		}
+	}
+	return strings.Join(parts, "")
+}
+
+func lowerFirst(s string) string {
+	if s == "" {
+		return s
+	}
+	return strings.ToLower(s[:1]) + s[1:]
+}
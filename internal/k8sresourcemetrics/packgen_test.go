@@ -0,0 +1,73 @@
+package k8sresmetric
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v3"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	quarkv1alpha1 "quark.netapp.io/otel-controller/internal/quark/v1alpha1"
+)
+
+func TestGeneratePack(t *testing.T) {
+	kinds := KnownKinds(quarkv1alpha1.SchemeGroupVersion)
+	assert.Contains(t, kinds, "NetAppVolumeReplication")
+	assert.NotContains(t, kinds, "NetAppVolumeReplicationList")
+
+	p, err := GeneratePack(quarkv1alpha1.SchemeGroupVersion.WithKind("NetAppVolumeReplication"), "ntap_")
+	assert.Nil(t, err)
+	assert.Equal(t, "netappvolumereplication", p.Name)
+
+	byName := make(map[string]MetricsConfig)
+	for _, m := range p.Metrics {
+		byName[m.Name] = m
+	}
+	size := byName["ntap_netappvolumereplication_status_last_transfer_size"]
+	assert.Equal(t, "$.status.lastTransferSize", size.Properties.Value)
+	newest := byName["ntap_netappvolumereplication_status_newest_snapshot_time_age_seconds"]
+	assert.Equal(t, "$.status.newestSnapshotTime", newest.Properties.Value)
+	assert.Equal(t, "age", newest.Properties.Unit)
+	cond := byName["ntap_netappvolumereplication_status_conditions"]
+	assert.Equal(t, "$.status.conditions[*].status", cond.Properties.Value)
+	assert.Equal(t, "$.status.conditions[*].type", cond.Properties.Labels["condition"])
+	info := byName["ntap_netappvolumereplication_info"]
+	assert.Equal(t, "info", info.MetricType)
+	assert.Equal(t, "$.status.mirrorState", info.Properties.Labels["statusMirrorState"])
+	assert.Equal(t, "$.spec.replicationPolicy", info.Properties.Labels["specReplicationPolicy"])
+	// Free text strings are not enums.
+	assert.NotContains(t, info.Properties.Labels, "specDisplayName")
+
+	// The generated pack round trips through YAML and evaluates against
+	// an object of the type it was generated from.
+	var buf bytes.Buffer
+	assert.Nil(t, WritePack(&buf, p))
+	read := &Pack{}
+	assert.Nil(t, yaml.Unmarshal(buf.Bytes(), read))
+	assert.Equal(t, p, read)
+
+	rep := &quarkv1alpha1.NetAppVolumeReplication{
+		ObjectMeta: metav1.ObjectMeta{Name: "rep", Namespace: "ns"},
+		Spec:       quarkv1alpha1.NetAppVolumeReplicationSpec{ReplicationPolicy: quarkv1alpha1.ReplicationPolicyMirrorLatest},
+		Status: quarkv1alpha1.NetAppVolumeReplicationStatus{
+			MirrorState:        quarkv1alpha1.MirrorStateMirrored,
+			LastTransferSize:   4096,
+			NewestSnapshotTime: metav1.NewTime(time.Now().Add(-time.Minute)),
+			Conditions: []quarkv1alpha1.NetAppVolumeReplicationCondition{
+				{Type: quarkv1alpha1.VolumeReplicationHealthy, Status: v1.ConditionTrue},
+			},
+		},
+	}
+	points := evalMetrics(t, read.Metrics, rep)
+	assert.Equal(t, 4096.0, points["ntap_netappvolumereplication_status_last_transfer_size"][0]["value"])
+	assert.InDelta(t, 60, points["ntap_netappvolumereplication_status_newest_snapshot_time_age_seconds"][0]["value"], 5)
+	assert.Equal(t, []map[string]interface{}{
+		{"value": 1.0, "name": "rep", "namespace": "ns", "condition": "Healthy"},
+	}, points["ntap_netappvolumereplication_status_conditions"])
+	infoPoint := points["ntap_netappvolumereplication_info"][0]
+	assert.Equal(t, 1.0, infoPoint["value"])
+	assert.Equal(t, "Mirrored", infoPoint["statusMirrorState"])
+	assert.Equal(t, "MirrorLatest", infoPoint["specReplicationPolicy"])
+}
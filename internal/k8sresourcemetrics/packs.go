@@ -0,0 +1,166 @@
+package k8sresmetric
+
+import (
+	"embed"
+	"fmt"
+	"io/fs"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+//go:embed packs/*.yaml
+var packFS embed.FS
+
+// Pack is a maintained set of metric definitions shipped with the
+// receiver, enabled by name from the receiver configuration.
+type Pack struct {
+	Name    string          `yaml:"name"`
+	Version string          `yaml:"version"`
+	Metrics []MetricsConfig `yaml:"metrics"`
+}
+
+// LoadPack returns the built-in pack referenced by ref, which is either
+// a pack name or name@version to pin the pack version.
+func LoadPack(ref string) (*Pack, error) {
+	name, version, _ := strings.Cut(ref, "@")
+
+	b, err := packFS.ReadFile("packs/" + name + ".yaml")
+	if err != nil {
+		return nil, fmt.Errorf("unknown metric pack %q", name)
+	}
+	p := &Pack{}
+	if err := yaml.Unmarshal(b, p); err != nil {
+		return nil, fmt.Errorf("error parsing metric pack %q: %w", name, err)
+	}
+	if version != "" && version != p.Version {
+		return nil, fmt.Errorf("metric pack %q is at version %s, not %s", name, p.Version, version)
+	}
+
+	return p, nil
+}
+
+// PackSettings switches a pack on or off and overrides its metrics.
+type PackSettings struct {
+	// Enabled turns the pack on or off regardless of whether it is
+	// listed in the enabled packs.
+	Enabled *bool `mapstructure:"enabled"`
+	// Metrics overrides metrics of the pack by name.
+	Metrics map[string]MetricOverride `mapstructure:"metrics"`
+}
+
+// MetricOverride changes a single metric of a pack.
+type MetricOverride struct {
+	// Enabled turns the metric off when false.
+	Enabled *bool `mapstructure:"enabled"`
+	// OnMissing replaces the onMissing policy of the metric.
+	OnMissing string `mapstructure:"onMissing"`
+	// Labels are added to the labels of the metric. A label set to
+	// the empty string is removed.
+	Labels map[string]string `mapstructure:"labels"`
+}
+
+// LoadPacks returns the metrics of every pack in refs, plus packs enabled
+// through settings, with the overrides in settings applied.
+func LoadPacks(refs []string, settings map[string]PackSettings) ([]MetricsConfig, error) {
+	enabled := make(map[string]string)
+	var order []string
+	for _, ref := range refs {
+		name, _, _ := strings.Cut(ref, "@")
+		if _, ok := enabled[name]; !ok {
+			order = append(order, name)
+		}
+		enabled[name] = ref
+	}
+	names := make([]string, 0, len(settings))
+	for name := range settings {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		// Settings of a misspelled pack would otherwise go unnoticed.
+		if _, err := fs.Stat(packFS, "packs/"+name+".yaml"); err != nil {
+			return nil, fmt.Errorf("settings for unknown metric pack %q", name)
+		}
+		s := settings[name]
+		if s.Enabled == nil {
+			continue
+		}
+		if !*s.Enabled {
+			delete(enabled, name)
+			continue
+		}
+		if _, ok := enabled[name]; !ok {
+			order = append(order, name)
+			enabled[name] = name
+		}
+	}
+
+	var metrics []MetricsConfig
+	for _, name := range order {
+		ref, ok := enabled[name]
+		if !ok {
+			continue
+		}
+		p, err := LoadPack(ref)
+		if err != nil {
+			return nil, err
+		}
+		pMetrics, err := applyOverrides(p, settings[name].Metrics)
+		if err != nil {
+			return nil, err
+		}
+		metrics = append(metrics, pMetrics...)
+	}
+
+	return metrics, nil
+}
+
+// applyOverrides returns the metrics of p changed by overrides.
+func applyOverrides(p *Pack, overrides map[string]MetricOverride) ([]MetricsConfig, error) {
+	for name := range overrides {
+		found := false
+		for _, m := range p.Metrics {
+			if m.Name == name {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("metric pack %q has no metric %s", p.Name, name)
+		}
+	}
+
+	var metrics []MetricsConfig
+	for _, m := range p.Metrics {
+		o, ok := overrides[m.Name]
+		if !ok {
+			metrics = append(metrics, m)
+			continue
+		}
+		if o.Enabled != nil && !*o.Enabled {
+			continue
+		}
+		if o.OnMissing != "" {
+			m.Properties.OnMissing = o.OnMissing
+		}
+		if len(o.Labels) > 0 {
+			labels := make(map[string]string, len(m.Properties.Labels)+len(o.Labels))
+			for k, v := range m.Properties.Labels {
+				labels[k] = v
+			}
+			for k, v := range o.Labels {
+				if v == "" {
+					delete(labels, k)
+					continue
+				}
+				labels[k] = v
+			}
+			m.Properties.Labels = labels
+		}
+		metrics = append(metrics, m)
+	}
+
+	return metrics, nil
+}
@@ -0,0 +1,484 @@
+package k8sresmetric
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spyzhov/ajson"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	quarkv1alpha1 "quark.netapp.io/otel-controller/internal/quark/v1alpha1"
+)
+
+// evalPack resolves every metric of the pack against objs and returns the
+// data points by metric name, each as label values keyed by label name
+// plus the "value" key.
+func evalPack(t *testing.T, name string, objs ...interface{}) map[string][]map[string]interface{} {
+	p, err := LoadPack(name)
+	assert.Nil(t, err)
+	return evalMetrics(t, p.Metrics, objs...)
+}
+
+// evalMetrics is evalPack for a list of metric definitions. The metrics
+// are resolved against the objects as JSON and, for typed objects, also
+// through the typed accessors, which must give the same result.
+func evalMetrics(t *testing.T, metrics []MetricsConfig, objs ...interface{}) map[string][]map[string]interface{} {
+	var nodes, typed []*object
+	for _, o := range objs {
+		b, err := json.Marshal(o)
+		assert.Nil(t, err)
+		n, err := ajson.Unmarshal(b)
+		assert.Nil(t, err)
+		nodes = append(nodes, jsonObject(n))
+		if ro, ok := o.(runtime.Object); ok {
+			typed = append(typed, typedObject(ro))
+		}
+	}
+	if len(typed) != len(nodes) {
+		typed = nil
+	}
+
+	tel, _ := newTestTelemetry(t)
+	km := &kMetrics{metricsMap: make(map[string]*MetricsInfo), logger: zap.NewNop(), telemetry: tel}
+	points := make(map[string][]map[string]interface{})
+	for _, m := range metrics {
+		assert.Nil(t, km.RegisterMetric(m))
+		km.resolve(context.Background(), m.Name, nodes)
+
+		r, err := km.Values(m.Name)
+		assert.Nil(t, err, m.Name)
+		if typed != nil {
+			km.resolve(context.Background(), m.Name, typed)
+			tr, err := km.Values(m.Name)
+			assert.Nil(t, err, m.Name)
+			assert.Equal(t, r, tr, m.Name)
+		}
+		for i, val := range r.Vals {
+			v, err := ConvertUnit(m.Properties.Unit, val)
+			dp := map[string]interface{}{"value": v}
+			if err != nil {
+				dp["value"] = err
+			}
+			for j, l := range km.LabelNames(m.Name) {
+				dp[l] = r.LabelValues[i][j]
+			}
+			points[m.Name] = append(points[m.Name], dp)
+		}
+	}
+	return points
+}
+
+func TestLoadPack(t *testing.T) {
+	p, err := LoadPack("netappvolume")
+	assert.Nil(t, err)
+	assert.Equal(t, "v1", p.Version)
+
+	_, err = LoadPack("netappvolume@" + p.Version)
+	assert.Nil(t, err)
+	_, err = LoadPack("netappvolume@v0")
+	assert.NotNil(t, err)
+	_, err = LoadPack("nosuchpack")
+	assert.NotNil(t, err)
+}
+
+func TestNetAppVolumePack(t *testing.T) {
+	vol := quarkv1alpha1.NetAppVolume{
+		ObjectMeta: metav1.ObjectMeta{Name: "vol1", Namespace: "ns"},
+		Spec: quarkv1alpha1.NetAppVolumeSpec{
+			Size:     resource.MustParse("100Gi"),
+			Protocol: "nfs",
+		},
+		Status: quarkv1alpha1.NetAppVolumeStatus{
+			Size:            resource.MustParse("100Gi"),
+			ProvisionedSize: resource.MustParse("120Gi"),
+			RestorePercent:  40,
+			NvramSize:       1024,
+			DataPvcs:        []string{"pvc1", "pvc2"},
+			ServiceLevel:    "premium",
+			Zone:            "us-east1-b",
+			QubeVersion:     "1.2.3",
+			VolumeUUID:      "uuid1",
+			Conditions: []quarkv1alpha1.NetAppVolumeCondition{
+				{Type: quarkv1alpha1.NetAppVolumeOnline, Status: v1.ConditionTrue},
+				{Type: quarkv1alpha1.NetAppVolumeResizeError, Status: v1.ConditionFalse},
+			},
+		},
+	}
+
+	points := evalPack(t, "netappvolume", vol)
+
+	assert.Equal(t, float64(100<<30), points["ntap_volume_spec_size_bytes"][0]["value"])
+	assert.Equal(t, float64(120<<30), points["ntap_volume_provisioned_size_bytes"][0]["value"])
+	assert.Equal(t, float64(0), points["ntap_volume_restore_cache_size_bytes"][0]["value"])
+	assert.Equal(t, float64(40), points["ntap_volume_restore_percent"][0]["value"])
+	assert.Equal(t, float64(1024), points["ntap_volume_nvram_size_bytes"][0]["value"])
+	assert.Equal(t, float64(2), points["ntap_volume_data_pvcs"][0]["value"])
+
+	conds := points["ntap_volume_condition"]
+	assert.Equal(t, 2, len(conds))
+	assert.Equal(t, "Online", conds[0]["condition"])
+	assert.Equal(t, float64(1), conds[0]["value"])
+	assert.Equal(t, "VolumeResizeError", conds[1]["condition"])
+	assert.Equal(t, float64(0), conds[1]["value"])
+
+	info := points["ntap_volume_info"][0]
+	assert.Equal(t, float64(1), info["value"])
+	assert.Equal(t, "nfs", info["protocol"])
+	assert.Equal(t, "premium", info["serviceLevel"])
+	assert.Equal(t, "us-east1-b", info["zone"])
+	assert.Equal(t, "1.2.3", info["qubeVersion"])
+	assert.Equal(t, "uuid1", info["volumeUUID"])
+}
+
+func TestNetAppVolumeReplicationPack(t *testing.T) {
+	now := time.Now()
+	rep := quarkv1alpha1.NetAppVolumeReplication{
+		ObjectMeta: metav1.ObjectMeta{Name: "rep1", Namespace: "ns"},
+		Spec: quarkv1alpha1.NetAppVolumeReplicationSpec{
+			SourceVolume:      quarkv1alpha1.NetAppVolumeReplicationVolume{VolumeUUID: "src", SVMUUID: "srcsvm", ClusterUUID: "srccluster"},
+			DestinationVolume: quarkv1alpha1.NetAppVolumeReplicationVolume{VolumeUUID: "dst", SVMUUID: "dstsvm", ClusterUUID: "dstcluster"},
+		},
+		Status: quarkv1alpha1.NetAppVolumeReplicationStatus{
+			NewestSnapshotTime:    metav1.NewTime(now.Add(-10 * time.Minute)),
+			MirrorState:           quarkv1alpha1.MirrorStateMirrored,
+			ReplicationStatus:     quarkv1alpha1.ReplicationStatusIdle,
+			LastTransferDuration:  30,
+			LastTransferSize:      4096,
+			TotalTransferBytes:    1 << 20,
+			TotalTransferTimeSecs: 300,
+			Conditions: []quarkv1alpha1.NetAppVolumeReplicationCondition{
+				{Type: quarkv1alpha1.VolumeReplicationHealthy, Status: v1.ConditionTrue},
+			},
+		},
+	}
+
+	points := evalPack(t, "netappvolumereplication", rep)
+
+	assert.Equal(t, float64(30), points["ntaprep_last_transfer_duration_seconds"][0]["value"])
+	assert.Equal(t, float64(4096), points["ntaprep_last_transfer_size_bytes"][0]["value"])
+	assert.Equal(t, float64(1<<20), points["ntaprep_total_transfer_bytes"][0]["value"])
+	assert.Equal(t, float64(300), points["ntaprep_total_transfer_time_seconds"][0]["value"])
+	assert.InDelta(t, 600, points["ntaprep_newest_snapshot_lag_seconds"][0]["value"], 5)
+	assert.Equal(t, ErrMissingValue, points["ntaprep_last_transfer_lag_seconds"][0]["value"])
+
+	states := map[interface{}]interface{}{}
+	for _, dp := range points["ntaprep_mirror_state"] {
+		states[dp["state"]] = dp["value"]
+	}
+	assert.Equal(t, map[interface{}]interface{}{"Uninitialized": float64(0), "Mirrored": float64(1), "Broken": float64(0)}, states)
+	assert.Equal(t, 2, len(points["ntaprep_replication_status"]))
+
+	cond := points["ntaprep_condition"][0]
+	assert.Equal(t, "Healthy", cond["condition"])
+	assert.Equal(t, float64(1), cond["value"])
+	assert.Equal(t, "src", cond["sourceUUID"])
+	assert.Equal(t, "dstcluster", cond["destinationClusterUUID"])
+}
+
+func TestNetAppStoragePoolPack(t *testing.T) {
+	migrated := metav1.NewTime(time.Now().Add(-time.Hour))
+	pool := quarkv1alpha1.NetAppStoragePool{
+		ObjectMeta: metav1.ObjectMeta{Name: "pool1", Namespace: "ns"},
+		Spec: quarkv1alpha1.NetAppStoragePoolSpec{
+			Size: resource.MustParse("1Ti"),
+		},
+		Status: quarkv1alpha1.NetAppStoragePoolStatus{
+			Size:                   resource.MustParse("1Ti"),
+			Zone:                   "us-east1-c",
+			AvailableZones:         []string{"us-east1-b", "us-east1-c"},
+			LastZoneMigrationCause: "ZoneOutage",
+			LastZoneMigrationTime:  &migrated,
+			ZoneMigrationCount:     3,
+			Conditions: []quarkv1alpha1.NetAppStoragePoolCondition{
+				{Type: quarkv1alpha1.NetAppStoragePoolOnline, Status: v1.ConditionTrue},
+				{Type: quarkv1alpha1.NetAppStoragePoolADDNSConfigurationError, Status: v1.ConditionTrue},
+			},
+		},
+	}
+
+	points := evalPack(t, "netappstoragepool", pool)
+
+	assert.Equal(t, float64(1<<40), points["ntap_storagepool_size_bytes"][0]["value"])
+	assert.Equal(t, float64(3), points["ntap_storagepool_zone_migrations_total"][0]["value"])
+	assert.InDelta(t, 3600, points["ntap_storagepool_last_zone_migration_age_seconds"][0]["value"], 5)
+	assert.Equal(t, float64(2), points["ntap_storagepool_available_zones"][0]["value"])
+	// globalAccess is omitted when false.
+	assert.Equal(t, ErrMissingValue, points["ntap_storagepool_global_access"][0]["value"])
+
+	conds := points["ntap_storagepool_condition"]
+	assert.Equal(t, 2, len(conds))
+	assert.Equal(t, "ADDNSConfigurationError", conds[1]["condition"])
+	assert.Equal(t, float64(1), conds[1]["value"])
+
+	zone := points["ntap_storagepool_zone_info"][0]
+	assert.Equal(t, "us-east1-c", zone["zone"])
+	assert.Equal(t, "ZoneOutage", zone["lastZoneMigrationCause"])
+}
+
+func TestQuarkPack(t *testing.T) {
+	q := quarkv1alpha1.Quark{
+		ObjectMeta: metav1.ObjectMeta{Name: "quark"},
+		Spec: quarkv1alpha1.QuarkSpec{
+			Version: "1.2.0",
+			Project: quarkv1alpha1.Project{ClusterName: "cluster1"},
+		},
+		Status: quarkv1alpha1.QuarkStatus{
+			Version: "1.2.0",
+			Images: quarkv1alpha1.Images{
+				PullPolicy: "IfNotPresent",
+				NVC:        "gcr.io/quark/nvc:1.2.0",
+				CSC:        "gcr.io/quark/csc:1.2.0",
+			},
+			Health: quarkv1alpha1.QuarkHealth{
+				NVC: quarkv1alpha1.QuarkReadyStatusReady,
+				CSC: quarkv1alpha1.QuarkReadyStatusNotReady,
+			},
+		},
+	}
+
+	points := evalPack(t, "quark", q)
+
+	health := map[string]interface{}{}
+	for _, dp := range points["quark_component_health"] {
+		assert.Equal(t, "cluster1", dp["cluster"])
+		health[dp["component"].(string)+"/"+dp["state"].(string)] = dp["value"]
+	}
+	// Every QuarkHealth field is reported.
+	assert.Equal(t, 2*reflect.TypeOf(quarkv1alpha1.QuarkHealth{}).NumField(), len(points["quark_component_health"]))
+	assert.Equal(t, float64(1), health["nvc/Ready"])
+	assert.Equal(t, float64(0), health["nvc/NotReady"])
+	assert.Equal(t, float64(1), health["csc/NotReady"])
+	assert.Equal(t, float64(0), health["kubeStateMetrics/Ready"])
+	// nhc and nodeMonitor, omitted when empty, are reported missing,
+	// which onMissing turns into 0.
+	assert.Equal(t, ErrMissingValue, health["nhc/Ready"])
+	assert.Equal(t, ErrMissingValue, health["nodeMonitor/NotReady"])
+
+	version := points["quark_version_info"][0]
+	assert.Equal(t, float64(1), version["value"])
+	assert.Equal(t, "1.2.0", version["version"])
+
+	images := map[interface{}]interface{}{}
+	for _, dp := range points["quark_image_info"] {
+		assert.Equal(t, float64(1), dp["value"])
+		images[dp["component"]] = dp["image"]
+	}
+	// Neither pullPolicy nor empty images, whether omitted like secd or
+	// not like dmap, are reported.
+	assert.Equal(t, map[interface{}]interface{}{
+		"nvc": "gcr.io/quark/nvc:1.2.0",
+		"csc": "gcr.io/quark/csc:1.2.0",
+	}, images)
+}
+
+// TestQuarkPackFields checks that the unions of the quark pack list every
+// field of QuarkHealth and Images, so that fields added to the types are
+// not silently left out.
+func TestQuarkPackFields(t *testing.T) {
+	fields := func(v interface{}, skip ...string) []string {
+		skipped := make(map[string]bool)
+		for _, name := range skip {
+			skipped[name] = true
+		}
+		var names []string
+		typ := reflect.TypeOf(v)
+		for i := 0; i < typ.NumField(); i++ {
+			name, _, _ := strings.Cut(typ.Field(i).Tag.Get("json"), ",")
+			if !skipped[name] {
+				names = append(names, name)
+			}
+		}
+		sort.Strings(names)
+		return names
+	}
+	want := map[string][]string{
+		"$.status.health": fields(quarkv1alpha1.QuarkHealth{}),
+		"$.status.images": fields(quarkv1alpha1.Images{}, "pullSecret", "pullPolicy"),
+	}
+
+	p, err := LoadPack("quark")
+	assert.Nil(t, err)
+	checked := 0
+	for _, m := range p.Metrics {
+		paths := []string{m.Properties.Value}
+		for _, l := range m.Properties.Labels {
+			paths = append(paths, l)
+		}
+		for _, path := range paths {
+			if !strings.HasPrefix(path, "$") {
+				continue
+			}
+			commands, err := ajson.ParseJSONPath(strings.TrimSuffix(path, "~"))
+			assert.Nil(t, err)
+			names := unionNames(commands[len(commands)-1])
+			if names == nil {
+				continue
+			}
+			parent := strings.Join(commands[:len(commands)-1], ".")
+			sort.Strings(names)
+			assert.Equal(t, want[parent], names, "%s: %s", m.Name, path)
+			checked++
+		}
+	}
+	assert.Equal(t, 5, checked)
+}
+
+func TestSnapshotPacks(t *testing.T) {
+	now := time.Now()
+	snap := quarkv1alpha1.NetAppVolumeSnapshot{
+		ObjectMeta: metav1.ObjectMeta{Name: "snap1", Namespace: "ns"},
+		Spec:       quarkv1alpha1.NetAppVolumeSnapshotSpec{Name: "daily"},
+		Status: quarkv1alpha1.NetAppVolumeSnapshotStatus{
+			Conditions: []quarkv1alpha1.NetAppVolumeSnapshotCondition{
+				{Type: quarkv1alpha1.SnapshotReady, Status: v1.ConditionTrue},
+			},
+		},
+	}
+	points := evalPack(t, "netappvolumesnapshot", snap)
+	assert.Equal(t, "Ready", points["ntap_snapshot_condition"][0]["condition"])
+	assert.Equal(t, float64(1), points["ntap_snapshot_condition"][0]["value"])
+
+	list := quarkv1alpha1.NetAppVolumeSnapshotsList{
+		ObjectMeta: metav1.ObjectMeta{Name: "vol1", Namespace: "ns"},
+		Status: quarkv1alpha1.NetAppVolumeSnapshotsListStatus{
+			DeletedSnapshots: []string{"old"},
+			Snapshots: []quarkv1alpha1.NetAppVolumeSnapshotInfo{
+				{Name: "s1", Size: resource.MustParse("1Gi"), CreationTime: metav1.NewTime(now.Add(-2 * time.Hour))},
+				{Name: "s3", Size: resource.MustParse("3Gi"), CreationTime: metav1.NewTime(now.Add(-10 * time.Minute))},
+				{Name: "s2", Size: resource.MustParse("2Gi"), CreationTime: metav1.NewTime(now.Add(-time.Hour))},
+			},
+		},
+	}
+	points = evalPack(t, "netappvolumesnapshotslist", list)
+	assert.Equal(t, float64(3), points["ntap_snapshotslist_snapshots"][0]["value"])
+	assert.Equal(t, float64(1), points["ntap_snapshotslist_deleted_snapshots"][0]["value"])
+	sizes := points["ntap_snapshotslist_snapshot_size_bytes"]
+	assert.Equal(t, 3, len(sizes))
+	assert.Equal(t, "s3", sizes[1]["snapshot"])
+	assert.Equal(t, float64(3<<30), sizes[1]["value"])
+	newest := points["ntap_snapshotslist_newest_snapshot_age_seconds"]
+	assert.Equal(t, 1, len(newest))
+	assert.NotContains(t, newest[0], "snapshot")
+	assert.InDelta(t, 600, newest[0]["value"], 5)
+
+	cloud := quarkv1alpha1.NetAppVolumeCloudSnapshot{
+		ObjectMeta: metav1.ObjectMeta{Name: "backup1", Namespace: "ns"},
+		Spec:       quarkv1alpha1.NetAppVolumeCloudSnapshotSpec{ProviderType: quarkv1alpha1.GoogleCloud},
+		Status: quarkv1alpha1.NetAppVolumeCloudSnapshotStatus{
+			BytesTransferred:  512,
+			LogicalSize:       1024,
+			CompletionPercent: 50,
+			CreationTime:      metav1.NewTime(now.Add(-time.Minute)),
+		},
+	}
+	points = evalPack(t, "netappvolumecloudsnapshot", cloud)
+	assert.Equal(t, float64(512), points["ntap_cloudsnapshot_bytes_transferred"][0]["value"])
+	assert.Equal(t, float64(1024), points["ntap_cloudsnapshot_logical_size_bytes"][0]["value"])
+	assert.Equal(t, float64(50), points["ntap_cloudsnapshot_completion_percent"][0]["value"])
+	assert.InDelta(t, 60, points["ntap_cloudsnapshot_age_seconds"][0]["value"], 5)
+	assert.Equal(t, "GoogleCloud", points["ntap_cloudsnapshot_info"][0]["providerType"])
+}
+
+func TestLoadPacks(t *testing.T) {
+	off, on := false, true
+
+	metrics, err := LoadPacks([]string{"netappvolumeusage", "netappclusterpeering"}, map[string]PackSettings{
+		"netappclusterpeering": {Enabled: &off},
+		"nodevolset":           {Enabled: &on},
+		"netappvolumeusage": {Metrics: map[string]MetricOverride{
+			"ntap_volumeusage_snapshot_used_bytes": {Enabled: &off},
+			"ntap_volumeusage_logical_used_bytes": {
+				OnMissing: "zero",
+				Labels:    map[string]string{"volume": "", "team": "storage"},
+			},
+		}},
+	})
+	assert.Nil(t, err)
+
+	byName := map[string]MetricsConfig{}
+	for _, m := range metrics {
+		byName[m.Name] = m
+	}
+	assert.Contains(t, byName, "ntap_nodevolset_ready_nodes")
+	assert.NotContains(t, byName, "ntap_clusterpeering_state")
+	assert.NotContains(t, byName, "ntap_volumeusage_snapshot_used_bytes")
+	logical := byName["ntap_volumeusage_logical_used_bytes"]
+	assert.Equal(t, "zero", logical.Properties.OnMissing)
+	assert.Equal(t, map[string]string{"name": "$.metadata.name", "namespace": "$.metadata.namespace", "team": "storage"}, logical.Properties.Labels)
+	// Overrides do not leak into the labels shared through YAML anchors.
+	assert.Equal(t, "$.spec.name", byName["ntap_volumeusage_physical_used_bytes"].Properties.Labels["volume"])
+
+	_, err = LoadPacks([]string{"nodevolset"}, map[string]PackSettings{
+		"nodevolset": {Metrics: map[string]MetricOverride{"nosuchmetric": {Enabled: &off}}},
+	})
+	assert.NotNil(t, err)
+
+	_, err = LoadPacks([]string{"nodevolset"}, map[string]PackSettings{
+		"nodevolsets": {Enabled: &off},
+	})
+	assert.ErrorContains(t, err, `unknown metric pack "nodevolsets"`)
+}
+
+func TestNodevolSetUsagePeeringPacks(t *testing.T) {
+	nvs := quarkv1alpha1.NodevolSet{
+		ObjectMeta: metav1.ObjectMeta{Name: "nodevol", Namespace: "ns"},
+		Status: quarkv1alpha1.NodevolSetStatus{
+			Version:         "1.2.0",
+			Ready:           5,
+			Recovering:      1,
+			AliveNodes:      5,
+			Orphan:          1,
+			TotalNodes:      6,
+			TotalFailedJobs: 2,
+		},
+	}
+	points := evalPack(t, "nodevolset", nvs)
+	assert.Equal(t, float64(5), points["ntap_nodevolset_ready_nodes"][0]["value"])
+	assert.Equal(t, float64(0), points["ntap_nodevolset_deleted_nodes"][0]["value"])
+	assert.Equal(t, float64(1), points["ntap_nodevolset_orphan_nodes"][0]["value"])
+	assert.Equal(t, float64(6), points["ntap_nodevolset_total_nodes"][0]["value"])
+	assert.Equal(t, float64(2), points["ntap_nodevolset_failed_jobs_total"][0]["value"])
+	assert.Equal(t, "1.2.0", points["ntap_nodevolset_info"][0]["version"])
+
+	usage := quarkv1alpha1.NetAppVolumeUsage{
+		ObjectMeta: metav1.ObjectMeta{Name: "usage1", Namespace: "ns"},
+		Spec:       quarkv1alpha1.NetAppVolumeUsageSpec{Name: "vol1"},
+		Status:     quarkv1alpha1.NetAppVolumeUsageStatus{LogicalUsed: 10, PhysicalUsed: 8, AllocatedSize: 100, SnapshotUsed: 2},
+	}
+	points = evalPack(t, "netappvolumeusage", usage)
+	assert.Equal(t, float64(10), points["ntap_volumeusage_logical_used_bytes"][0]["value"])
+	assert.Equal(t, float64(8), points["ntap_volumeusage_physical_used_bytes"][0]["value"])
+	assert.Equal(t, float64(100), points["ntap_volumeusage_allocated_size_bytes"][0]["value"])
+	assert.Equal(t, float64(2), points["ntap_volumeusage_snapshot_used_bytes"][0]["value"])
+	assert.Equal(t, "vol1", points["ntap_volumeusage_snapshot_used_bytes"][0]["volume"])
+
+	peering := quarkv1alpha1.NetAppClusterPeering{
+		ObjectMeta: metav1.ObjectMeta{Name: "peer1", Namespace: "ns"},
+		Spec:       quarkv1alpha1.NetAppClusterPeeringSpec{RemoteEndpoint: "10.0.0.1"},
+		Status: quarkv1alpha1.NetAppClusterPeeringStatus{
+			State: quarkv1alpha1.ClusterPeeringStatePeered,
+			Conditions: []quarkv1alpha1.NetAppClusterPeeringCondition{
+				{Type: quarkv1alpha1.ClusterPeeringReady, Status: v1.ConditionTrue},
+			},
+		},
+	}
+	points = evalPack(t, "netappclusterpeering", peering)
+	for _, dp := range points["ntap_clusterpeering_state"] {
+		if dp["state"] == "Peered" {
+			assert.Equal(t, float64(1), dp["value"])
+		} else {
+			assert.Equal(t, float64(0), dp["value"])
+		}
+	}
+	assert.Equal(t, "ClusterPeeringReady", points["ntap_clusterpeering_condition"][0]["condition"])
+	assert.Equal(t, "10.0.0.1", points["ntap_clusterpeering_info"][0]["remoteEndpoint"])
+}
@@ -0,0 +1,96 @@
+package k8sresmetric
+
+import (
+	"context"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+const telemetryPrefix = "k8sresmetrics_"
+
+// Telemetry holds the instruments the receiver uses to report on its own
+// health, so that silently failing metrics show up on the collector's
+// dashboards.
+type Telemetry struct {
+	evalErrors    metric.Int64Counter
+	objectsListed metric.Int64Counter
+	listDuration  metric.Float64Histogram
+	listErrors    metric.Int64Counter
+	seriesEmitted metric.Int64Counter
+	catalogLoads  metric.Int64Counter
+}
+
+// NewTelemetry creates the receiver instruments from meter.
+func NewTelemetry(meter metric.Meter) (*Telemetry, error) {
+	var err error
+	t := &Telemetry{}
+
+	t.evalErrors, err = meter.Int64Counter(telemetryPrefix+"evaluation_errors",
+		metric.WithDescription("Number of values that could not be resolved or converted, per metric."))
+	if err != nil {
+		return nil, err
+	}
+	t.objectsListed, err = meter.Int64Counter(telemetryPrefix+"objects_listed",
+		metric.WithDescription("Number of Kubernetes objects listed, per GVK."))
+	if err != nil {
+		return nil, err
+	}
+	t.listDuration, err = meter.Float64Histogram(telemetryPrefix+"list_duration",
+		metric.WithDescription("Latency of Kubernetes List calls, per GVK."),
+		metric.WithUnit("s"))
+	if err != nil {
+		return nil, err
+	}
+	t.listErrors, err = meter.Int64Counter(telemetryPrefix+"list_errors",
+		metric.WithDescription("Number of failed Kubernetes List calls, per GVK."))
+	if err != nil {
+		return nil, err
+	}
+	t.seriesEmitted, err = meter.Int64Counter(telemetryPrefix+"series_emitted",
+		metric.WithDescription("Number of data points emitted, per metric."))
+	if err != nil {
+		return nil, err
+	}
+	t.catalogLoads, err = meter.Int64Counter(telemetryPrefix+"catalog_loads",
+		metric.WithDescription("Number of metric catalog loads, by result."))
+	if err != nil {
+		return nil, err
+	}
+
+	return t, nil
+}
+
+// RecordEvaluationError counts a value of metric that could not be used.
+// reason tells which stage failed, e.g. "path" or "unit".
+func (t *Telemetry) RecordEvaluationError(ctx context.Context, metricName string, reason string) {
+	t.evalErrors.Add(ctx, 1, metric.WithAttributes(
+		attribute.String("metric", metricName),
+		attribute.String("reason", reason)))
+}
+
+// RecordList records the outcome of a List call for gvk.
+func (t *Telemetry) RecordList(ctx context.Context, gvk string, objects int, elapsed time.Duration, err error) {
+	attrs := metric.WithAttributes(attribute.String("gvk", gvk))
+	t.listDuration.Record(ctx, elapsed.Seconds(), attrs)
+	if err != nil {
+		t.listErrors.Add(ctx, 1, attrs)
+		return
+	}
+	t.objectsListed.Add(ctx, int64(objects), attrs)
+}
+
+// RecordSeries counts the data points emitted for metric in a scrape.
+func (t *Telemetry) RecordSeries(ctx context.Context, metricName string, series int) {
+	t.seriesEmitted.Add(ctx, int64(series), metric.WithAttributes(attribute.String("metric", metricName)))
+}
+
+// RecordCatalogLoad records whether loading the metric catalog succeeded.
+func (t *Telemetry) RecordCatalogLoad(ctx context.Context, err error) {
+	result := "success"
+	if err != nil {
+		result = "failure"
+	}
+	t.catalogLoads.Add(ctx, 1, metric.WithAttributes(attribute.String("result", result)))
+}
@@ -0,0 +1,248 @@
+package k8sresmetric
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/spyzhov/ajson"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// object is a listed object. Objects of kinds registered in the scheme are
+// kept typed and read through accessors; they are only parsed as JSON
+// when a path needs it. Other objects are parsed as JSON when listed.
+type object struct {
+	typed runtime.Object
+	node  *ajson.Node
+	err   error
+}
+
+// jsonObject returns an object for an already parsed JSON node.
+func jsonObject(node *ajson.Node) *object {
+	return &object{node: node}
+}
+
+// typedObject returns an object for a typed object.
+func typedObject(obj runtime.Object) *object {
+	return &object{typed: obj}
+}
+
+// JSON returns the object parsed as JSON, parsing typed objects on first
+// use.
+func (o *object) JSON() (*ajson.Node, error) {
+	if o.node != nil || o.err != nil {
+		return o.node, o.err
+	}
+	b, err := json.Marshal(o.typed)
+	if err != nil {
+		o.err = err
+		return nil, err
+	}
+	o.node, o.err = ajson.Unmarshal(b)
+	return o.node, o.err
+}
+
+// typedList returns an empty typed list for gvk when the kind is
+// registered in the scheme.
+func typedList(gvk schema.GroupVersionKind) (client.ObjectList, bool) {
+	listGVK := gvk.GroupVersion().WithKind(gvk.Kind + "List")
+	if !rscheme.Recognizes(gvk) || !rscheme.Recognizes(listGVK) {
+		return nil, false
+	}
+	obj, err := rscheme.New(listGVK)
+	if err != nil {
+		return nil, false
+	}
+	list, ok := obj.(client.ObjectList)
+	return list, ok
+}
+
+// accessor reads the field selected by a simple JSONPath, such as
+// $.status.lastTransferSize or $.metadata.labels.app, straight from a
+// typed object. It returns what evaluating the path against the object's
+// JSON would: numbers as float64, quantities and times as strings, and
+// nil for missing, omitted or null fields.
+type accessor struct {
+	steps []accessorStep
+	// length is set for paths ending in .length on a slice.
+	length bool
+}
+
+type accessorStep struct {
+	// index is the field index in the struct, or nil for a map key.
+	index []int
+	key   string
+	// omitEmpty is set when the field is left out of the JSON when
+	// empty.
+	omitEmpty bool
+}
+
+// compileAccessor compiles path for objects of type t. It returns nil
+// when the path is not a plain field path, or selects something that is
+// not a single scalar, so that the path is evaluated as JSONPath instead.
+func compileAccessor(t reflect.Type, path string) *accessor {
+	rest, ok := strings.CutPrefix(path, "$.")
+	if !ok || strings.ContainsAny(rest, "[]*()?@'\"~ ") {
+		return nil
+	}
+
+	a := &accessor{}
+	names := strings.Split(rest, ".")
+	for i, name := range names {
+		for t.Kind() == reflect.Pointer {
+			t = t.Elem()
+		}
+		if name == "" {
+			return nil
+		}
+		if name == "length" && i == len(names)-1 && t.Kind() == reflect.Slice {
+			a.length = true
+			return a
+		}
+		switch t.Kind() {
+		case reflect.Struct:
+			if t == quantityType || t == timeType {
+				return nil
+			}
+			f, omitEmpty, ok := jsonField(t, name)
+			if !ok {
+				return nil
+			}
+			a.steps = append(a.steps, accessorStep{index: f.Index, omitEmpty: omitEmpty})
+			t = f.Type
+		case reflect.Map:
+			if t.Key().Kind() != reflect.String {
+				return nil
+			}
+			a.steps = append(a.steps, accessorStep{key: name})
+			t = t.Elem()
+		default:
+			return nil
+		}
+	}
+
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	switch {
+	case t == quantityType, t == timeType:
+	case t.Kind() == reflect.String, t.Kind() == reflect.Bool,
+		t.Kind() >= reflect.Int && t.Kind() <= reflect.Float64:
+	default:
+		return nil
+	}
+	return a
+}
+
+// jsonField returns the field of t whose JSON name is name, looking into
+// inlined structs.
+func jsonField(t reflect.Type, name string) (reflect.StructField, bool, bool) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		tag, opts, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if tag == "-" {
+			continue
+		}
+		if tag == "" && f.Anonymous {
+			ft := f.Type
+			if ft.Kind() == reflect.Pointer {
+				ft = ft.Elem()
+			}
+			if ft.Kind() != reflect.Struct {
+				continue
+			}
+			if inner, omitEmpty, ok := jsonField(ft, name); ok {
+				inner.Index = append(append([]int{}, f.Index...), inner.Index...)
+				return inner, omitEmpty, true
+			}
+			continue
+		}
+		if tag == "" {
+			tag = f.Name
+		}
+		if tag == name {
+			return f, strings.Contains(","+opts+",", ",omitempty,"), true
+		}
+	}
+	return reflect.StructField{}, false, false
+}
+
+// Get returns the value selected by the accessor in obj.
+func (a *accessor) Get(obj runtime.Object) interface{} {
+	v := reflect.ValueOf(obj)
+	omitEmpty := false
+	for _, s := range a.steps {
+		for v.Kind() == reflect.Pointer {
+			if v.IsNil() {
+				return nil
+			}
+			v = v.Elem()
+		}
+		if s.index != nil {
+			// The field is absent when an inlined pointer is nil.
+			var err error
+			if v, err = v.FieldByIndexErr(s.index); err != nil {
+				return nil
+			}
+		} else {
+			if v.IsNil() {
+				return nil
+			}
+			v = v.MapIndex(reflect.ValueOf(s.key).Convert(v.Type().Key()))
+			if !v.IsValid() {
+				return nil
+			}
+		}
+		omitEmpty = s.omitEmpty
+	}
+	for v.Kind() == reflect.Pointer {
+		if v.IsNil() {
+			return nil
+		}
+		v = v.Elem()
+		// A set pointer is never omitted.
+		omitEmpty = false
+	}
+
+	if a.length {
+		if v.IsNil() || (omitEmpty && v.Len() == 0) {
+			return nil
+		}
+		return float64(v.Len())
+	}
+
+	switch v.Type() {
+	case quantityType:
+		q := v.Interface().(resource.Quantity)
+		return q.String()
+	case timeType:
+		t := v.Interface().(metav1.Time)
+		if t.IsZero() {
+			return nil
+		}
+		return t.UTC().Format(time.RFC3339)
+	}
+	if omitEmpty && v.IsZero() {
+		return nil
+	}
+	switch v.Kind() {
+	case reflect.String:
+		return v.String()
+	case reflect.Bool:
+		return v.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return float64(v.Uint())
+	}
+	return v.Float()
+}
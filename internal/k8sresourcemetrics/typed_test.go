@@ -0,0 +1,158 @@
+package k8sresmetric
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	"go.uber.org/zap"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	quarkv1alpha1 "quark.netapp.io/otel-controller/internal/quark/v1alpha1"
+)
+
+func TestAccessor(t *testing.T) {
+	transferred := time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)
+	rep := &quarkv1alpha1.NetAppVolumeReplication{
+		ObjectMeta: metav1.ObjectMeta{Name: "rep", Labels: map[string]string{"app": "db"}},
+		Status: quarkv1alpha1.NetAppVolumeReplicationStatus{
+			MirrorState:         quarkv1alpha1.MirrorStateMirrored,
+			LastTransferSize:    4096,
+			LastTransferEndTime: metav1.NewTime(transferred),
+			Conditions:          []quarkv1alpha1.NetAppVolumeReplicationCondition{{}, {}},
+		},
+	}
+	typ := reflect.TypeOf(rep)
+
+	for path, want := range map[string]interface{}{
+		"$.metadata.name":                   "rep",
+		"$.metadata.labels.app":             "db",
+		"$.metadata.labels.missing":         nil,
+		"$.status.mirrorState":              "Mirrored",
+		"$.status.lastTransferSize":         4096.0,
+		"$.status.lastTransferEndTime":      "2024-03-01T10:00:00Z",
+		"$.status.newestSnapshotTime":       nil,
+		"$.status.totalTransferBytes":       nil,
+		"$.status.conditions.length":        2.0,
+		"$.metadata.ownerReferences.length": nil,
+	} {
+		a := compileAccessor(typ, path)
+		if assert.NotNil(t, a, path) {
+			assert.Equal(t, want, a.Get(rep), path)
+		}
+	}
+
+	// Paths that are not a single field are left to JSONPath.
+	for _, path := range []string{
+		"$.status.conditions[*].status",
+		"$.status.conditions",
+		"$.status",
+		"$.status.unknown",
+		"$.metadata.labels.*~",
+		"",
+	} {
+		assert.Nil(t, compileAccessor(typ, path), path)
+	}
+}
+
+// InlineStatus is inlined by inliningObject through a pointer.
+type InlineStatus struct {
+	Phase string `json:"phase"`
+}
+
+type inliningObject struct {
+	metav1.TypeMeta `json:",inline"`
+	Status          struct {
+		*InlineStatus
+	} `json:"status"`
+}
+
+func (o *inliningObject) DeepCopyObject() runtime.Object {
+	c := *o
+	return &c
+}
+
+func TestAccessorNilInline(t *testing.T) {
+	obj := &inliningObject{}
+	a := compileAccessor(reflect.TypeOf(obj), "$.status.phase")
+	if assert.NotNil(t, a) {
+		assert.Nil(t, a.Get(obj))
+		obj.Status.InlineStatus = &InlineStatus{Phase: "Ready"}
+		assert.Equal(t, "Ready", a.Get(obj))
+	}
+}
+
+func TestUpdateTyped(t *testing.T) {
+	defer setTestClient(t, &quarkv1alpha1.NetAppVolumeReplication{
+		ObjectMeta: metav1.ObjectMeta{Name: "rep", Namespace: "ns"},
+		Status:     quarkv1alpha1.NetAppVolumeReplicationStatus{LastTransferSize: 4096},
+	})()
+
+	tel, _ := newTestTelemetry(t)
+	km := &kMetrics{metricsMap: make(map[string]*MetricsInfo), logger: zap.NewNop(), telemetry: tel}
+	m := MetricsConfig{Name: "size"}
+	m.Properties.Object = "NetAppVolumeReplication"
+	m.Properties.Value = "$.status.lastTransferSize"
+	m.Properties.Labels = map[string]string{"name": "$.metadata.name", "kind": "$.kind"}
+	assert.Nil(t, km.RegisterMetric(m))
+
+	assert.Nil(t, km.Update(context.Background()))
+	assert.Equal(t, reflect.TypeOf(&quarkv1alpha1.NetAppVolumeReplication{}), km.metricsMap["size"].accessorType)
+	r, err := km.Values("size")
+	assert.Nil(t, err)
+	assert.Equal(t, []interface{}{4096.0}, r.Vals)
+	// $.kind needs JSONPath, which sees the kind set on the object.
+	labels := make(map[string]string)
+	for i, l := range km.LabelNames("size") {
+		labels[l] = r.LabelValues[0][i]
+	}
+	assert.Equal(t, map[string]string{"name": "rep", "kind": "NetAppVolumeReplication"}, labels)
+	assert.NotNil(t, km.metricsMap["size"].valueAccessor)
+}
+
+func TestUpdateSharedSnapshot(t *testing.T) {
+	defer setTestClient(t,
+		&quarkv1alpha1.NetAppVolumeReplication{ObjectMeta: metav1.ObjectMeta{Name: "a", Namespace: "ns", Labels: map[string]string{"tier": "gold"}}},
+		&quarkv1alpha1.NetAppVolumeReplication{ObjectMeta: metav1.ObjectMeta{Name: "b", Namespace: "ns"}},
+	)()
+
+	tel, reader := newTestTelemetry(t)
+	km := &kMetrics{metricsMap: make(map[string]*MetricsInfo), logger: zap.NewNop(), telemetry: tel}
+	for _, name := range []string{"size", "bytes", "gold"} {
+		m := MetricsConfig{Name: name}
+		m.Properties.Object = "NetAppVolumeReplication"
+		m.Properties.Value = "$.status.lastTransferSize"
+		if name == "gold" {
+			m.Properties.Selector = "tier=gold"
+		}
+		assert.Nil(t, km.RegisterMetric(m))
+	}
+
+	assert.Nil(t, km.Update(context.Background()))
+	for name, n := range map[string]int{"size": 2, "bytes": 2, "gold": 1} {
+		r, err := km.Values(name)
+		assert.Nil(t, err)
+		assert.Len(t, r.Vals, n, name)
+	}
+
+	// One List for the metrics without a selector and one for the
+	// metric with a selector.
+	rm := metricdata.ResourceMetrics{}
+	assert.Nil(t, reader.Collect(context.Background(), &rm))
+	for _, m := range rm.ScopeMetrics[0].Metrics {
+		if m.Name == "k8sresmetrics_list_duration" {
+			assert.Equal(t, uint64(2), m.Data.(metricdata.Histogram[float64]).DataPoints[0].Count)
+		}
+	}
+}
+
+func TestRegisterMetricInvalidSelector(t *testing.T) {
+	km := &kMetrics{metricsMap: make(map[string]*MetricsInfo), logger: zap.NewNop()}
+	m := MetricsConfig{Name: "gold"}
+	m.Properties.Value = "$.status.size"
+	m.Properties.Selector = "tier in (gold"
+	assert.ErrorContains(t, km.RegisterMetric(m), `metric gold: invalid selector "tier in (gold"`)
+}
@@ -2,13 +2,36 @@ package k8sresmetric
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"strconv"
 	"strings"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/resource"
 )
 
+// ErrMissingValue is returned when the resolved value is absent or null.
+var ErrMissingValue = errors.New("value is missing")
+
 func ConvertUnit(unit string, val interface{}) (float64, error) {
 	var convertedVal float64
+
+	switch unit {
+	case "timestamp":
+		ts, err := coerceToTime(val)
+		if err != nil {
+			return 0.0, err
+		}
+		return float64(ts.UnixNano()) / float64(time.Second), nil
+	case "age":
+		ts, err := coerceToTime(val)
+		if err != nil {
+			return 0.0, err
+		}
+		return time.Since(ts).Seconds(), nil
+	}
+
 	value, err := CoerceToFloat64(val)
 	if err != nil {
 		return 0.0, err
@@ -30,6 +53,36 @@ func ConvertUnit(unit string, val interface{}) (float64, error) {
 	}
 	return convertedVal, nil
 }
+
+// metricUnit returns the UCUM unit of the values ConvertUnit produces for
+// the given unit directive.
+func metricUnit(unit string) string {
+	switch unit {
+	case "timestamp", "age", "ms", "microsec":
+		return "s"
+	case "Mb", "MiB", "bytes":
+		return "By"
+	case "count":
+		return "1"
+	case "percent":
+		return "%"
+	default:
+		return unit
+	}
+}
+
+// coerceToTime parses an RFC 3339 timestamp such as metav1.Time.
+func coerceToTime(val interface{}) (time.Time, error) {
+	switch t := val.(type) {
+	case string:
+		return time.Parse(time.RFC3339, t)
+	case nil:
+		return time.Time{}, ErrMissingValue
+	default:
+		return time.Time{}, fmt.Errorf("unable to coerce %#v to time", val)
+	}
+}
+
 func msToSec(value float64) float64 {
 	return value / 1000
 }
@@ -84,14 +137,23 @@ func CoerceToFloat64(val interface{}) (float64, error) {
 		} else if valStr == "false" || valStr == "notready" {
 			valStr = "0.0"
 		}
-		return strconv.ParseFloat(valStr, 64)
+		f, err := strconv.ParseFloat(valStr, 64)
+		if err == nil {
+			return f, nil
+		}
+		// Sizes such as spec.size are serialized as quantities.
+		q, qErr := resource.ParseQuantity(t)
+		if qErr != nil {
+			return 0.0, err
+		}
+		return q.AsApproximateFloat64(), nil
 	case bool:
 		if t {
 			return 1.0, nil
 		}
 		return 0.0, nil
 	case nil:
-		return 0.0, nil
+		return 0.0, ErrMissingValue
 	default:
 		return 0.0, fmt.Errorf("unable to coerce %#v to float64", val)
 	}
@@ -0,0 +1,13 @@
+package k8sresmetricsreciever
+
+import (
+	"testing"
+
+	"go.uber.org/goleak"
+)
+
+// The opencensus worker is started by an init function in a collector
+// dependency and never stops, so it is not a leak of this receiver.
+func TestMain(m *testing.M) {
+	goleak.VerifyTestMain(m, goleak.IgnoreTopFunction("go.opencensus.io/stats/view.(*worker).start"))
+}
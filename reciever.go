@@ -2,55 +2,152 @@ package k8sresmetricsreciever
 
 import (
 	"context"
+	"errors"
+	"os"
+	"sync"
 
-	log "github.com/sirupsen/logrus"
 	"go.opentelemetry.io/collector/component"
 	"go.opentelemetry.io/collector/consumer"
 	"go.opentelemetry.io/collector/pdata/pmetric"
 	"go.opentelemetry.io/collector/receiver"
 	"go.opentelemetry.io/collector/receiver/scraperhelper"
+	"go.uber.org/zap"
 	kresmetrics "quark.netapp.io/otel-controller/internal/k8sresourcemetrics"
 	"quark.netapp.io/otel-controller/internal/metadata"
 	"sigs.k8s.io/controller-runtime/pkg/client/config"
 )
 
 type k8sresmetrics struct {
-	config *K8sResMetricsConfig
+	config     *K8sResMetricsConfig
+	logger     *zap.Logger
+	telemetry  *kresmetrics.Telemetry
+	collectors []*kresmetrics.Collector
+
+	// ctx lives from Start until Shutdown and bounds every scrape
+	// and background goroutine started by the receiver.
+	ctx    context.Context
+	cancel context.CancelFunc
+	// wg tracks scrapes and goroutines that must finish before
+	// Shutdown returns.
+	wg sync.WaitGroup
+	// mu orders wg.Add in scrape before wg.Wait in Shutdown: once
+	// shutdown is set no scrape starts.
+	mu       sync.Mutex
+	shutdown bool
 }
 
+// errShutdown is returned by scrapes once the receiver is shut down.
+var errShutdown = errors.New("receiver is shut down")
+
 func (r *k8sresmetrics) Start(context.Context, component.Host) error {
+	// The context passed to Start is only valid for the duration of
+	// the call, so long lived work hangs off a context of our own.
+	r.ctx, r.cancel = context.WithCancel(context.Background())
 
 	rConfig, err := config.GetConfig()
 	if err != nil {
-		log.Errorf("error in getting KUBECONFIG %v", err)
+		r.logger.Error("error in getting KUBECONFIG", zap.Error(err))
 		return nil
 	}
 
 	err = kresmetrics.SetClients(rConfig)
 	if err != nil {
-		log.Fatalf("Error building extra clientset: %s", err.Error())
+		r.logger.Error("error building kubernetes clients", zap.Error(err))
+		return err
 	}
 
-	// err = krmetrics.SetCollectors(supCfg.K8sResourceMetricYaml)
-	// if err != nil {
-	// 	log.Error("error setting resource to metrics collector", err.Error())
-	// }
+	packMetrics, err := kresmetrics.LoadPacks(r.config.Packs, r.config.PackSettings)
+	if err != nil {
+		r.telemetry.RecordCatalogLoad(r.ctx, err)
+		return err
+	}
+	if r.config.ResRef == "" && len(packMetrics) == 0 {
+		return nil
+	}
+	var b []byte
+	if r.config.ResRef != "" {
+		b, err = os.ReadFile(r.config.ResRef)
+		if err != nil {
+			r.telemetry.RecordCatalogLoad(r.ctx, err)
+			return err
+		}
+	}
+	r.collectors, err = kresmetrics.SetCollectors(string(b), packMetrics, r.config.List, r.logger, r.telemetry)
+	r.telemetry.RecordCatalogLoad(r.ctx, err)
+	if err != nil {
+		r.logger.Error("error setting resource to metrics collector", zap.String("path", r.config.ResRef), zap.Error(err))
+		return err
+	}
 
 	return nil
 }
+
+// Shutdown cancels the receiver context, which aborts in-flight
+// Kubernetes API calls, and waits for running scrapes to return
+// within the deadline of ctx.
+func (r *k8sresmetrics) Shutdown(ctx context.Context) error {
+	r.mu.Lock()
+	r.shutdown = true
+	r.mu.Unlock()
+
+	if r.cancel != nil {
+		r.cancel()
+	}
+
+	done := make(chan struct{})
+	go func() {
+		r.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
 func (r *k8sresmetrics) scrape(ctx context.Context) (pmetric.Metrics, error) {
 	md := pmetric.NewMetrics()
 
-	return md, nil
+	r.mu.Lock()
+	if r.shutdown {
+		r.mu.Unlock()
+		return md, errShutdown
+	}
+	r.wg.Add(1)
+	r.mu.Unlock()
+	defer r.wg.Done()
+
+	// Abort the scrape as soon as either the scrape times out
+	// or the receiver is shut down.
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stop := context.AfterFunc(r.ctx, cancel)
+	defer stop()
+
+	for _, c := range r.collectors {
+		c.Collect(ctx).ResourceMetrics().MoveAndAppendTo(md.ResourceMetrics())
+	}
+
+	return md, ctx.Err()
 }
 
 func newK8sResMetrics(ctx context.Context, params receiver.CreateSettings, cfg *K8sResMetricsConfig, consumer consumer.Metrics) (receiver.Metrics, error) {
 
+	tel, err := kresmetrics.NewTelemetry(metadata.Meter(params.TelemetrySettings))
+	if err != nil {
+		return nil, err
+	}
+
 	k8s := &k8sresmetrics{
-		config: cfg,
+		config:    cfg,
+		logger:    params.TelemetrySettings.Logger,
+		telemetry: tel,
 	}
 
-	scrp, err := scraperhelper.NewScraper(metadata.Type, k8s.scrape, scraperhelper.WithStart(k8s.Start))
+	scrp, err := scraperhelper.NewScraper(metadata.Type, k8s.scrape, scraperhelper.WithStart(k8s.Start), scraperhelper.WithShutdown(k8s.Shutdown))
 	if err != nil {
 		return nil, err
 	}
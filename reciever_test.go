@@ -10,6 +10,9 @@ import (
 	"go.opentelemetry.io/collector/consumer/consumertest"
 	"go.opentelemetry.io/collector/receiver/receivertest"
 	"go.opentelemetry.io/collector/receiver/scraperhelper"
+	"go.opentelemetry.io/otel/metric/noop"
+	"go.uber.org/zap"
+	kresmetrics "quark.netapp.io/otel-controller/internal/k8sresourcemetrics"
 )
 
 func TestNewReceiver(t *testing.T) {
@@ -42,4 +45,71 @@ func TestReceiverStart(t *testing.T) {
 	err = rm.Start(context.Background(), componenttest.NewNopHost())
 
 	time.Sleep(2 * time.Minute)
+
+	assert.Nil(t, rm.Shutdown(context.Background()))
+}
+
+func TestReceiverShutdown(t *testing.T) {
+	k8s := &K8sResMetricsConfig{
+		ScraperControllerSettings: scraperhelper.ScraperControllerSettings{
+			CollectionInterval: 10 * time.Millisecond,
+		},
+	}
+
+	rm, err := newK8sResMetrics(context.Background(), receivertest.NewNopCreateSettings(), k8s, consumertest.NewNop())
+	assert.Nil(t, err)
+
+	assert.Nil(t, rm.Start(context.Background(), componenttest.NewNopHost()))
+	time.Sleep(100 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	assert.Nil(t, rm.Shutdown(ctx))
+}
+
+// blockingCollector blocks in Update until its context is done.
+type blockingCollector struct {
+	started chan struct{}
+}
+
+func (b *blockingCollector) RegisterMetric(m kresmetrics.MetricsConfig) error { return nil }
+
+func (b *blockingCollector) LabelNames(metric string) []string { return nil }
+
+func (b *blockingCollector) Update(ctx context.Context) error {
+	close(b.started)
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func (b *blockingCollector) Values(metric string) (kresmetrics.Result, error) {
+	return kresmetrics.Result{}, nil
+}
+
+func TestShutdownCancelsScrape(t *testing.T) {
+	tel, err := kresmetrics.NewTelemetry(noop.NewMeterProvider().Meter(""))
+	assert.Nil(t, err)
+	b := &blockingCollector{started: make(chan struct{})}
+	r := &k8sresmetrics{
+		logger:     zap.NewNop(),
+		telemetry:  tel,
+		collectors: []*kresmetrics.Collector{kresmetrics.NewCollector(b, zap.NewNop(), tel)},
+	}
+	r.ctx, r.cancel = context.WithCancel(context.Background())
+
+	errs := make(chan error, 1)
+	go func() {
+		_, err := r.scrape(context.Background())
+		errs <- err
+	}()
+	<-b.started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	assert.Nil(t, r.Shutdown(ctx))
+	assert.ErrorIs(t, <-errs, context.Canceled)
+
+	// No scrape starts once the receiver is shut down.
+	_, err = r.scrape(context.Background())
+	assert.ErrorIs(t, err, errShutdown)
 }